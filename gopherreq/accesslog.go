@@ -0,0 +1,286 @@
+package gopherreq
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopherreq/gopherreq/common"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The layout of the access log lines.
+type AccessLogFormat int
+
+const (
+	// host ident authuser [date] "request-line" status bytes
+	CommonLogFormat AccessLogFormat = iota
+	// The common format followed by "referer" "user-agent".
+	CombinedLogFormat
+	// A JSON object per line with every field of the AccessLogEntry.
+	JSONLogFormat
+)
+
+// Configures the access log of the server.
+type AccessLogConfig struct {
+	Format AccessLogFormat
+	Output io.Writer // Receives the lines. Use a RotatingFile to limit the size on disk. Defaults to os.Stdout.
+}
+
+// AccessLogEntry describes a request served by the server.
+type AccessLogEntry struct {
+	Time         time.Time         `json:"time"`
+	RemoteAddr   string            `json:"remote_addr"`
+	Method       common.HttpMethod `json:"method"`
+	RawURI       string            `json:"uri"`
+	Version      string            `json:"version"`
+	Status       common.StatusCode `json:"status"`
+	BytesWritten int64             `json:"bytes"`
+	Duration     time.Duration     `json:"-"`
+	Referer      string            `json:"referer,omitempty"`
+	UserAgent    string            `json:"user_agent,omitempty"`
+	RequestID    string            `json:"request_id,omitempty"`
+}
+
+// Builds the entry for a request which started at the given time.
+func newAccessLogEntry(req *HttpRequest, start time.Time, status common.StatusCode, written int64) AccessLogEntry {
+	return AccessLogEntry{
+		Time:         start,
+		RemoteAddr:   req.RemoteAddr,
+		Method:       req.Method,
+		RawURI:       req.RawURI,
+		Version:      req.Version,
+		Status:       status,
+		BytesWritten: written,
+		Duration:     time.Since(start),
+		Referer:      req.Headers.Get("Referer").String(),
+		UserAgent:    req.Headers.Get("User-Agent").String(),
+		RequestID:    req.RequestID,
+	}
+}
+
+// Returns the entry as a single line in the format including the trailing newline.
+func (e AccessLogEntry) Format(format AccessLogFormat) string {
+	switch format {
+	case JSONLogFormat:
+		return e.jsonLine()
+	case CombinedLogFormat:
+		return fmt.Sprintf("%s %s %s\n", e.commonLine(), quoteLogField(e.Referer), quoteLogField(e.UserAgent))
+	}
+
+	return e.commonLine() + "\n"
+}
+
+func (e AccessLogEntry) commonLine() string {
+	requestLine := fmt.Sprintf("%s %s %s", e.Method, e.RawURI, e.Version)
+
+	return fmt.Sprintf("%s - - [%s] %s %d %d",
+		remoteHost(e.RemoteAddr),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quoteLogField(requestLine),
+		e.Status,
+		e.BytesWritten)
+}
+
+func (e AccessLogEntry) jsonLine() string {
+	// The duration is logged in milliseconds which is easier to query than the nanoseconds of time.Duration.
+	type jsonEntry struct {
+		AccessLogEntry
+		DurationMs float64 `json:"duration_ms"`
+	}
+
+	data, err := json.Marshal(jsonEntry{
+		AccessLogEntry: e,
+		DurationMs:     float64(e.Duration.Microseconds()) / 1000,
+	})
+	if err != nil {
+		return "{}\n"
+	}
+
+	return string(data) + "\n"
+}
+
+// Quotes a field for the Apache formats. Quotes, backslashes and control characters are escaped so the client can not forge log lines.
+func quoteLogField(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+
+	builder := strings.Builder{}
+	builder.WriteByte('"')
+
+	for _, r := range value {
+		switch {
+		case r == '"' || r == '\\':
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&builder, "\\x%02x", r)
+		default:
+			builder.WriteRune(r)
+		}
+	}
+
+	builder.WriteByte('"')
+
+	return builder.String()
+}
+
+// Writes the entries to the output one line at a time.
+type accessLog struct {
+	mu     sync.Mutex
+	format AccessLogFormat
+	out    io.Writer
+}
+
+func newAccessLog(cfg AccessLogConfig) *accessLog {
+	out := cfg.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	return &accessLog{format: cfg.Format, out: out}
+}
+
+func (l *accessLog) write(entry AccessLogEntry) {
+	line := entry.Format(l.format)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	io.WriteString(l.out, line)
+}
+
+/*
+RotatingFile is a log file which is rotated once it grows over MaxBytes.
+
+On rotation the file is renamed to path.1, the older backups are shifted to path.2, path.3 and so on and the ones over
+MaxBackups are deleted. It is safe for concurrent use.
+
+A failed rotation does not stop the log. The lines keep going to the file at path and the rotation is tried again on the next
+write.
+*/
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File // Nil when the file could not be reopened after a rotation. The next write tries again.
+	size       int64
+	closed     bool
+}
+
+// Opens or creates the file at path in append mode.
+func NewRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RotatingFile) open() (err error) {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	return
+}
+
+func (r *RotatingFile) Write(data []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	if r.file == nil {
+		err = r.open()
+		if err != nil {
+			return
+		}
+	}
+
+	// A write is never split between two files. A single write larger than the limit still goes to a fresh file.
+	var rotateErr error
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxBytes {
+		rotateErr = r.rotate()
+		if r.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err = r.file.Write(data)
+	r.size += int64(n)
+
+	// The data is written, the error only tells the rotation failed.
+	if err == nil && rotateErr != nil {
+		err = fmt.Errorf("rotating %s: %w", r.path, rotateErr)
+	}
+
+	return
+}
+
+// Moves the file to the first backup and opens a new one. When that fails the file at path is opened again to keep logging.
+func (r *RotatingFile) rotate() (err error) {
+	r.file.Close()
+	r.file = nil
+
+	if r.maxBackups <= 0 {
+		err = os.Remove(r.path)
+	} else {
+		os.Remove(r.backupPath(r.maxBackups))
+		for index := r.maxBackups - 1; index >= 1; index-- {
+			os.Rename(r.backupPath(index), r.backupPath(index+1))
+		}
+
+		err = os.Rename(r.path, r.backupPath(1))
+	}
+
+	openErr := r.open()
+	if err == nil {
+		err = openErr
+	}
+
+	return
+}
+
+func (r *RotatingFile) backupPath(index int) string {
+	return fmt.Sprintf("%s.%d", r.path, index)
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || r.file == nil {
+		r.closed = true
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	r.closed = true
+
+	return err
+}
@@ -1,5 +1,14 @@
 package gopherreq
 
+import (
+	"bufio"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
 /*
 This Reader allows to read the contents of the body.
 
@@ -11,3 +20,289 @@ type ResponseBodyReader interface {
 	// This method reads from the input pipeline.
 	Read() ([]byte, error)
 }
+
+// NoBody is the body of a request which does not have one. It always returns io.EOF.
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// Bodies left unread by the handler are discarded up to this size so the connection can be reused. Larger ones close the connection.
+const MAX_DISCARD_BODY_BYTES = int64(256 << 10)
+
+// Wraps the body read from the connection. A read deadline hit while reading it is reported as httperr.ErrRequestBodyTimeout.
+type connBody struct {
+	body     io.Reader
+	timedOut bool
+}
+
+func newConnBody(body io.Reader) *connBody {
+	return &connBody{body: body}
+}
+
+func (b *connBody) Read(p []byte) (n int, err error) {
+	n, err = b.body.Read(p)
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		b.timedOut = true
+		err = httperr.ErrRequestBodyTimeout
+	}
+
+	return
+}
+
+// Reports if reading the body of the request hit the read deadline.
+func bodyTimedOut(body RequestBody) bool {
+	connBody, ok := body.(*connBody)
+
+	return ok && connBody.timedOut
+}
+
+// Reads a body with a known length from the connection.
+// If the connection ends before the length is reached it returns io.ErrUnexpectedEOF just like io.ReadFull.
+type fixedLengthBody struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (b *fixedLengthBody) Read(p []byte) (n int, err error) {
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err = b.reader.Read(p)
+	b.remaining -= int64(n)
+
+	if err == io.EOF && b.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return
+}
+
+/*
+Decodes a body sent with the chunked transfer coding.
+
+	chunked-body = *chunk last-chunk trailer-section CRLF
+	chunk        = chunk-size [ chunk-ext ] CRLF chunk-data CRLF
+	last-chunk   = 1*("0") [ chunk-ext ] CRLF
+
+Chunk extensions are ignored. The trailer fields are merged into the trailers once the last chunk is read.
+Ref - https://www.rfc-editor.org/rfc/rfc9112#section-7.1
+*/
+type chunkedBody struct {
+	reader    *bufio.Reader
+	trailers  Headers
+	opts      headerOptions // The trailer fields are parsed like the header fields.
+	remaining int64         // Bytes left in the current chunk.
+	err       error         // Sticky error returned once the body is finished or broken.
+}
+
+func newChunkedBody(reader *bufio.Reader, trailers Headers, opts headerOptions) *chunkedBody {
+	return &chunkedBody{reader: reader, trailers: trailers, opts: opts}
+}
+
+func (b *chunkedBody) Read(p []byte) (n int, err error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.remaining == 0 {
+		err = b.startChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err = b.reader.Read(p)
+	b.remaining -= int64(n)
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	// Every chunk data is followed by a CRLF.
+	if b.remaining == 0 {
+		line, readErr := b.readLine()
+		if readErr == nil && len(line) != 0 {
+			readErr = httperr.ErrInvalidChunkedBody
+		}
+		b.err = readErr
+	}
+
+	return n, nil
+}
+
+/*
+Reads the next chunk whole. Once the last chunk and the trailers are read it returns io.EOF without data.
+
+Chunks larger than maxBytes are returned over several calls so a peer announcing a huge chunk can not make us allocate it.
+*/
+func (b *chunkedBody) readChunk(maxBytes int64) (data []byte, err error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if b.remaining == 0 {
+		err = b.startChunk()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data = make([]byte, min(b.remaining, maxBytes))
+
+	n, err := io.ReadFull(b, data)
+
+	return data[:n], err
+}
+
+// Reads the size of the next chunk. At the last chunk the trailers are read and the body ends with io.EOF.
+func (b *chunkedBody) startChunk() (err error) {
+	b.remaining, err = b.readChunkSize()
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	if b.remaining == 0 {
+		b.err = b.readTrailers()
+		if b.err == nil {
+			b.err = io.EOF
+		}
+		return b.err
+	}
+
+	return
+}
+
+/*
+Reads the size line of a chunk.
+
+	chunk-size = 1*HEXDIG
+	chunk-ext  = *( BWS ";" BWS chunk-ext-name [ BWS "=" BWS chunk-ext-val ] )
+
+In strict mode the size must start the line and only the whitespace before an extension may follow it. A server reading
+" 5" or "5 " differently than the proxy in front of it would split the body at another place. The lenient mode trims the
+whitespace around the size like the original parser did.
+*/
+func (b *chunkedBody) readChunkSize() (size int64, err error) {
+	line, err := b.readLine()
+	if err != nil {
+		return
+	}
+
+	if b.opts.lenient {
+		line, _, _ = strings.Cut(line, ";")
+		line = strings.Trim(line, " \t")
+	}
+
+	end := strings.IndexFunc(line, func(r rune) bool { return !isHexDigit(r) })
+	if end == -1 {
+		end = len(line)
+	}
+
+	// The chunk extensions are ignored.
+	rawSize, extensions := line[:end], line[end:]
+	if rawSize == "" || (extensions != "" && !strings.HasPrefix(strings.TrimLeft(extensions, " \t"), ";")) {
+		return 0, httperr.ErrInvalidChunkedBody
+	}
+
+	size, err = strconv.ParseInt(rawSize, 16, 64)
+	if err != nil {
+		return 0, httperr.ErrInvalidChunkedBody
+	}
+
+	return
+}
+
+// Reads the trailer section which ends with an empty line. It is limited to the size of a header section.
+func (b *chunkedBody) readTrailers() error {
+	maxBytes := b.opts.maxBytes
+	if maxBytes <= 0 {
+		maxBytes = int(HEADER_LIMIT_BYTES)
+	}
+
+	total := 0
+	lines := []string{}
+
+	for {
+		line, err := b.readLine()
+		if err != nil {
+			return err
+		}
+
+		if line == "" {
+			break
+		}
+
+		total += len(line)
+		if total > maxBytes {
+			return httperr.ErrTrailerLimitExceeded
+		}
+
+		lines = append(lines, line)
+	}
+
+	trailers, err := parseRequestHeaders(strings.Join(lines, "\r\n"), b.opts)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range trailers {
+		// Fields which control the framing of the message are not allowed in the trailers.
+		if key == "Content-Length" || key == "Transfer-Encoding" || key == "Host" {
+			continue
+		}
+
+		for _, value := range values {
+			b.trailers.Apsert(key, value)
+		}
+	}
+
+	return nil
+}
+
+// Reads a single line without the line ending. Lines longer than the read buffer are rejected.
+func (b *chunkedBody) readLine() (line string, err error) {
+	raw, err := b.reader.ReadSlice('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err == bufio.ErrBufferFull {
+		return "", httperr.ErrInvalidChunkedBody
+	}
+	if err != nil {
+		return
+	}
+
+	line = strings.TrimSuffix(strings.TrimSuffix(string(raw), "\n"), "\r")
+
+	return
+}
+
+// Discards the part of the body which the handler did not read. It reports if the body was read completely and the connection can be reused.
+func discardBody(body RequestBody) bool {
+	if body == nil {
+		return true
+	}
+
+	discarded, err := io.CopyN(io.Discard, body, MAX_DISCARD_BODY_BYTES+1)
+
+	return err == io.EOF && discarded <= MAX_DISCARD_BODY_BYTES
+}
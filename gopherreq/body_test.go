@@ -0,0 +1,94 @@
+package gopherreq
+
+import (
+	"bufio"
+	"errors"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChunkedBody(t *testing.T) {
+	strict := headerOptions{maxBytes: 64, maxCount: DEFAULT_MAX_HEADER_COUNT, maxFieldBytes: DEFAULT_MAX_HEADER_FIELD_BYTES}
+	lenient := strict
+	lenient.lenient = true
+
+	tests := []struct {
+		name     string
+		body     string
+		opts     headerOptions
+		want     string
+		trailers Headers
+		err      error
+	}{
+		{"single chunk", "5\r\nhello\r\n0\r\n\r\n", strict, "hello", Headers{}, nil},
+		{"several chunks", "5\r\nhello\r\n1\r\n \r\n5\r\nworld\r\n0\r\n\r\n", strict, "hello world", Headers{}, nil},
+		{"upper case hex", "A\r\n0123456789\r\n0\r\n\r\n", strict, "0123456789", Headers{}, nil},
+		{"extensions", "5;name=value;flag\r\nhello\r\n000;last\r\n\r\n", strict, "hello", Headers{}, nil},
+		{"whitespace before an extension", "5 \t;name\r\nhello\r\n0\r\n\r\n", strict, "hello", Headers{}, nil},
+		{"trailers", "5\r\nhello\r\n0\r\nX-Checksum: abc\r\nX-Checksum: def\r\n\r\n", strict, "hello", Headers{"X-Checksum": {"abc", "def"}}, nil},
+		{"framing trailers dropped", "5\r\nhello\r\n0\r\nContent-Length: 10\r\nHost: evil\r\nX-Ok: 1\r\n\r\n", strict, "hello", Headers{"X-Ok": {"1"}}, nil},
+		{"trailers over the limit", "0\r\nX-Long: " + strings.Repeat("a", 64) + "\r\n\r\n", strict, "", nil, httperr.ErrTrailerLimitExceeded},
+		{"invalid trailer", "0\r\nX-Bad : 1\r\n\r\n", strict, "", nil, httperr.ErrInvalidHeader},
+		{"size overflowing int64", "10000000000000000\r\n", strict, "", nil, httperr.ErrInvalidChunkedBody},
+		{"empty size", "\r\nhello\r\n0\r\n\r\n", strict, "", nil, httperr.ErrInvalidChunkedBody},
+		{"signed size", "+5\r\nhello\r\n0\r\n\r\n", strict, "", nil, httperr.ErrInvalidChunkedBody},
+		{"non hex size", "5g\r\nhello\r\n0\r\n\r\n", strict, "", nil, httperr.ErrInvalidChunkedBody},
+		{"leading whitespace", " 5\r\nhello\r\n0\r\n\r\n", strict, "", nil, httperr.ErrInvalidChunkedBody},
+		{"trailing whitespace", "5 \r\nhello\r\n0\r\n\r\n", strict, "", nil, httperr.ErrInvalidChunkedBody},
+		{"lenient leading whitespace", " 5\r\nhello\r\n0\r\n\r\n", lenient, "hello", Headers{}, nil},
+		{"lenient trailing whitespace", "5\t\r\nhello\r\n0\r\n\r\n", lenient, "hello", Headers{}, nil},
+		{"missing CRLF after the data", "5\r\nhelloX\r\n0\r\n\r\n", strict, "hello", nil, httperr.ErrInvalidChunkedBody},
+		{"truncated data", "5\r\nhel", strict, "hel", nil, io.ErrUnexpectedEOF},
+		{"truncated last chunk", "5\r\nhello\r\n0", strict, "hello", nil, io.ErrUnexpectedEOF},
+		{"missing final CRLF", "5\r\nhello\r\n0\r\n", strict, "hello", nil, io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trailers := Headers{}
+			body := newChunkedBody(bufio.NewReader(strings.NewReader(test.body)), trailers, test.opts)
+
+			data, err := io.ReadAll(body)
+
+			if test.err == nil && err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("ReadAll error = %v, want %v", err, test.err)
+			}
+
+			if string(data) != test.want {
+				t.Fatalf("body = %q, want %q", data, test.want)
+			}
+
+			if test.trailers != nil && !maps.EqualFunc(trailers, test.trailers, slices.Equal) {
+				t.Fatalf("trailers = %v, want %v", trailers, test.trailers)
+			}
+		})
+	}
+}
+
+// A chunk larger than the read size is returned over several reads and every read after the last chunk returns io.EOF.
+func TestChunkedBodyReadAfterEnd(t *testing.T) {
+	body := newChunkedBody(bufio.NewReader(strings.NewReader("3\r\nabc\r\n0\r\n\r\n")), Headers{}, headerOptions{})
+
+	data, err := body.readChunk(2)
+	if err != nil || string(data) != "ab" {
+		t.Fatalf("first readChunk = %q, %v, want \"ab\"", data, err)
+	}
+
+	data, err = body.readChunk(2)
+	if err != nil || string(data) != "c" {
+		t.Fatalf("second readChunk = %q, %v, want \"c\"", data, err)
+	}
+
+	for range 2 {
+		if _, err := body.readChunk(2); err != io.EOF {
+			t.Fatalf("readChunk after the last chunk = %v, want io.EOF", err)
+		}
+	}
+}
@@ -0,0 +1,665 @@
+package gopherreq
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The User-Agent sent when neither the request nor the client set one.
+const DEFAULT_USER_AGENT = "gopherreq"
+
+// Largest part of a body returned by a single read of a ResponseBodyReader.
+const MAX_BODY_READ_BYTES = int64(1 << 20)
+
+/*
+Client sends HTTP/1.1 requests and reads their responses. The zero value is ready to use.
+
+The connections are opened and reused by the Transport of the client. The responses are parsed with the same code as the
+requests received by the server, so the same limits and strictness apply.
+
+Redirects are followed and the idempotent requests are retried when the connection fails or the server is overloaded, see Do.
+*/
+type Client struct {
+	Transport              *Transport    // Opens and pools the connections. Defaults to DefaultTransport.
+	UserAgent              string        // Sent when the request has no User-Agent. Defaults to DEFAULT_USER_AGENT.
+	MaxResponseHeaderBytes int           // Size of the status line and the headers of a response. Defaults to HEADER_LIMIT_BYTES.
+	Timeout                time.Duration // Time for the whole exchange, the redirects, the retries and the reading of the body included. Zero means no limit.
+	AttemptTimeout         time.Duration // Time for each attempt until the headers of the response are read. A timed out attempt is retried. Zero means no limit.
+	MaxRedirects           int           // Redirects followed for a request. Defaults to DEFAULT_MAX_REDIRECTS. Negative returns the redirects to the caller.
+	MaxRetries             int           // Times a request is sent again after the first attempt. Defaults to DEFAULT_MAX_RETRIES. Negative disables the retries.
+	RetryBaseDelay         time.Duration // Wait before the first retry, doubled for every next one. Defaults to DEFAULT_RETRY_BASE_DELAY.
+	RetryMaxDelay          time.Duration // Longest wait between two attempts. Defaults to DEFAULT_RETRY_MAX_DELAY.
+	NoDefaultHeaders       bool          // Sends the headers of the request as they are, without a User-Agent or a Content-Length of zero added. Used by proxies.
+}
+
+// DefaultClient is a Client with the default settings for the callers which do not need their own.
+var DefaultClient = &Client{}
+
+// Returned when the server closed the connection without sending anything back.
+var errNoResponse = fmt.Errorf("%w: connection closed before the response", io.ErrUnexpectedEOF)
+
+// A deadline in the past which makes the pending reads and writes of a connection fail at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+/*
+Do sends the request and returns the response once its headers are read. The body is streamed from the connection by the
+ResponseBodyReader of the response, and the connection goes back to the pool of the transport once the body is read completely
+or closed.
+
+The URI must be absolute with the http or https scheme. The Host header defaults to the host of the URI and a body without a
+Content-Length header is sent with the chunked coding, unless its length can be found out like for a bytes.Reader.
+
+The redirects are followed up to MaxRedirects and the response to the last request is returned. The requests with an idempotent
+method are retried up to MaxRetries when the connection fails or the server answers 429 or 503, see retryDelay. A body can only
+be sent again if it implements io.Seeker, otherwise the redirect or the failure is returned as is.
+
+The context of the request and the Timeout of the client stop the exchange, including the reading of the body.
+*/
+func (c *Client) Do(req HttpRequest) (*HttpResponse, error) {
+	err := prepareClientRequest(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		req.ctx, cancel = context.WithTimeout(req.Context(), c.Timeout)
+	}
+
+	rewind := bodyRewinder(req.Body)
+
+	for redirects := 0; ; redirects++ {
+		resp, err := c.send(&req, rewind)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		var next HttpRequest
+		follow := false
+		if c.MaxRedirects >= 0 {
+			next, follow, err = redirectRequest(resp, rewind)
+		}
+		if err == nil && follow && redirects >= c.maxRedirects() {
+			err = fmt.Errorf("%w: %d redirects", httperr.ErrTooManyRedirects, redirects)
+		}
+		if err != nil {
+			resp.Close()
+			cancel()
+			return nil, err
+		}
+
+		if !follow {
+			// The timeout also covers the body so it only ends with it.
+			if resp.body.err != nil {
+				cancel()
+			} else {
+				resp.body.cancel = cancel
+			}
+			return resp, nil
+		}
+
+		discardResponse(resp)
+		req = next
+
+		if req.Body == NoBody {
+			rewind = bodyRewinder(NoBody)
+		}
+	}
+}
+
+// Sends the request once and retries it as long as retryDelay allows.
+func (c *Client) send(req *HttpRequest, rewind func() error) (*HttpResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt != 0 {
+			err := rewind()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.attempt(req)
+
+		delay, retry := c.retryDelay(req, resp, err, attempt, rewind != nil)
+		if !retry {
+			return resp, err
+		}
+
+		if resp != nil {
+			discardResponse(resp)
+		}
+
+		err = sleepContext(req.Context(), delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+/*
+Makes a single attempt within AttemptTimeout. A pooled connection may be closed by the server while the request is sent, so
+requests with an idempotent method and without a body are sent once more on another connection before the attempt fails.
+*/
+func (c *Client) attempt(req *HttpRequest) (*HttpResponse, error) {
+	ctx := req.Context()
+	if c.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.AttemptTimeout)
+		defer cancel()
+	}
+
+	transport := c.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+
+	for retried := false; ; retried = true {
+		pc, err := transport.getConn(ctx, req.URI.Scheme, req.URI.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.roundTrip(ctx, pc, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		transport.closeConn(pc)
+
+		if !retried && pc.reused && isStaleConnError(req, err) {
+			continue
+		}
+
+		return nil, err
+	}
+}
+
+/*
+Sends the request on the connection and reads the headers of the response. The body owns the connection afterwards.
+
+The exchange stops when ctx ends. Reading the body stops when the context of the request ends.
+*/
+func (c *Client) roundTrip(ctx context.Context, pc *persistConn, req *HttpRequest) (*HttpResponse, error) {
+	stop := context.AfterFunc(ctx, func() {
+		pc.conn.SetDeadline(aLongTimeAgo)
+	})
+
+	err := c.writeRequest(pc.conn, req)
+
+	var resp *HttpResponse
+	if err == nil {
+		resp, err = c.readResponse(pc.reader, req)
+	}
+
+	// The connection has a deadline in the past once the context ended, so it is of no use anymore.
+	if !stop() && err == nil {
+		return nil, ctx.Err()
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp.body.ctx = req.Context()
+	resp.body.pc = pc
+	resp.body.reusable = resp.keepsAlive()
+	resp.body.stop = context.AfterFunc(resp.body.ctx, func() {
+		pc.conn.SetReadDeadline(aLongTimeAgo)
+	})
+
+	// Nothing is left to read so the connection is released right away.
+	if resp.body.reader == NoBody {
+		resp.body.finish(io.EOF)
+	}
+
+	return resp, nil
+}
+
+/*
+Reports if the request can be sent again after failing on a reused connection. It must be safe to repeat and the error must
+show the server closed the connection before handling it. The body would have to be read again so only requests without one
+are retried.
+*/
+func isStaleConnError(req *HttpRequest, err error) bool {
+	if !req.Method.IsIdempotent() || req.Body != NoBody {
+		return false
+	}
+
+	return errors.Is(err, errNoResponse) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// Checks the request and fills the fields every request needs.
+func prepareClientRequest(req *HttpRequest) error {
+	if req.Method == "" {
+		req.Method = common.Get
+	}
+
+	if !isToken(string(req.Method)) {
+		return fmt.Errorf("%w: method %q", httperr.ErrInvalidRequest, req.Method)
+	}
+
+	req.URI.Scheme = strings.ToLower(req.URI.Scheme)
+	if req.URI.Scheme != "http" && req.URI.Scheme != "https" {
+		return fmt.Errorf("%w: %q", httperr.ErrUnsupportedScheme, req.URI.Scheme)
+	}
+
+	if req.URI.Host == "" {
+		return fmt.Errorf("%w: missing host", httperr.ErrInvalidRequest)
+	}
+
+	// The headers are copied since they are changed before being sent and the request belongs to the caller. The keys of a map
+	// built by hand, like TE or X-API-Key, are canonicalized so the lookups by name find them.
+	headers := make(Headers, len(req.Headers))
+	for key, values := range req.Headers {
+		canonicalKey := common.GetCanonicalName(key)
+		headers[canonicalKey] = append(headers[canonicalKey], values...)
+	}
+	req.Headers = headers
+
+	for key, values := range req.Headers {
+		if !isToken(key) {
+			return fmt.Errorf("%w: header name %q", httperr.ErrInvalidRequest, key)
+		}
+
+		for _, value := range values {
+			if !isValidFieldValue(value.String()) {
+				return fmt.Errorf("%w: invalid value for header %s", httperr.ErrInvalidRequest, key)
+			}
+		}
+	}
+
+	if req.Body == nil {
+		req.Body = NoBody
+	}
+
+	return nil
+}
+
+func hostWithPort(scheme string, host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// Writes the request line, the headers and the body to the connection.
+func (c *Client) writeRequest(conn io.Writer, req *HttpRequest) (err error) {
+	writer := bufio.NewWriter(conn)
+	headers := req.Headers
+
+	if headers.Get("Host") == "" {
+		headers.Set("Host", HeaderValue(req.URI.Host))
+	}
+
+	if headers.Get("User-Agent") == "" && !c.NoDefaultHeaders {
+		userAgent := c.UserAgent
+		if userAgent == "" {
+			userAgent = DEFAULT_USER_AGENT
+		}
+		headers.Set("User-Agent", HeaderValue(userAgent))
+	}
+
+	if cookies := req.Cookies.All(); len(cookies) != 0 && headers.Get("Cookie") == "" {
+		pairs := make([]string, 0, len(cookies))
+		for _, cookie := range cookies {
+			pairs = append(pairs, cookie.Name+"="+cookie.Value)
+		}
+		headers.Set("Cookie", HeaderValue(strings.Join(pairs, "; ")))
+	}
+
+	bodyLen, chunked, err := requestBodyLength(req)
+	if err != nil {
+		return
+	}
+
+	if chunked {
+		headers.Set("Transfer-Encoding", "chunked")
+		headers.Remove("Content-Length")
+	} else if bodyLen > 0 || (methodExpectsBody(req.Method) && !c.NoDefaultHeaders) {
+		headers.Set("Content-Length", HeaderValue(strconv.FormatInt(bodyLen, 10)))
+	}
+
+	_, err = fmt.Fprintf(writer, "%s %s HTTP/1.1%s", req.Method, requestTarget(req), common.CRLF)
+	if err != nil {
+		return
+	}
+
+	// The Host goes first as recommended, the others in a stable order.
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		if key != "Host" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	keys = append([]string{"Host"}, keys...)
+
+	for _, key := range keys {
+		for _, value := range headers[key] {
+			_, err = fmt.Fprintf(writer, "%s: %s%s", key, value, common.CRLF)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	_, err = io.WriteString(writer, common.CRLF)
+	if err != nil {
+		return
+	}
+
+	if chunked {
+		chunkedWriter := newChunkedWriter(writer)
+
+		_, err = io.Copy(chunkedWriter, req.Body)
+		if err != nil {
+			return
+		}
+
+		err = chunkedWriter.Close()
+	} else if bodyLen > 0 {
+		var copied int64
+		copied, err = io.CopyN(writer, req.Body, bodyLen)
+		if err == io.EOF {
+			err = fmt.Errorf("%w: body is shorter than its Content-Length, %d of %d bytes", httperr.ErrInvalidRequest, copied, bodyLen)
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	return writer.Flush()
+}
+
+/*
+Returns the length of the request body from the Content-Length header or from the body itself. The body is sent chunked when
+its length can not be known.
+*/
+func requestBodyLength(req *HttpRequest) (length int64, chunked bool, err error) {
+	if req.Body == NoBody {
+		return 0, false, nil
+	}
+
+	if req.Headers.HasToken("Transfer-Encoding", "chunked") {
+		return 0, true, nil
+	}
+
+	if rawLen := req.Headers.Get("Content-Length"); rawLen != "" {
+		length, err = parseContentLength([]HeaderValue{rawLen})
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: %v", httperr.ErrInvalidRequest, err)
+		}
+		return length, false, nil
+	}
+
+	// Readers over memory like bytes.Buffer, bytes.Reader and strings.Reader know what is left.
+	if lengther, ok := req.Body.(interface{ Len() int }); ok {
+		return int64(lengther.Len()), false, nil
+	}
+
+	return 0, true, nil
+}
+
+// The methods whose requests usually have a body. They get a Content-Length of zero even without one.
+func methodExpectsBody(method common.HttpMethod) bool {
+	return method == common.Post || method == common.Put || method == common.Patch
+}
+
+// Returns the request target in the form the request needs.
+func requestTarget(req *HttpRequest) string {
+	switch {
+	case req.Method == common.Connect || req.TargetForm == AuthorityForm:
+		return req.URI.Host
+	case req.TargetForm == AsteriskForm || req.URI.Path == "*":
+		return "*"
+	case req.TargetForm == AbsoluteForm:
+		return req.URI.String()
+	}
+
+	return req.URI.RequestURI()
+}
+
+/*
+Reads the status line and the headers of the response and prepares its body.
+
+The interim 1xx responses are skipped, except 101 Switching Protocols which ends the exchange. The length of the body follows
+the rules of RFC 9112. Ref - https://www.rfc-editor.org/rfc/rfc9112#section-6.3
+*/
+func (c *Client) readResponse(reader *bufio.Reader, req *HttpRequest) (resp *HttpResponse, err error) {
+	maxHeaderBytes := c.MaxResponseHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = int(HEADER_LIMIT_BYTES)
+	}
+
+	opts := headerOptions{maxBytes: maxHeaderBytes, maxCount: DEFAULT_MAX_HEADER_COUNT, maxFieldBytes: DEFAULT_MAX_HEADER_FIELD_BYTES}
+
+	for received := false; ; received = true {
+		section, err := readHeaderSection(reader, maxHeaderBytes)
+		if err == io.EOF && !received {
+			return nil, errNoResponse
+		}
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		statusLine, rawHeaders, _ := strings.Cut(section, "\r\n")
+
+		resp, err = parseStatusLine(statusLine)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Headers, err = parseRequestHeaders(rawHeaders, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", httperr.ErrInvalidResponse, err)
+		}
+
+		if resp.StatusCode >= 200 || resp.StatusCode == SWITCHING_PROTOCOLS {
+			break
+		}
+	}
+
+	resp.Request = req
+	resp.ContentLength = -1
+	resp.body = &clientBody{}
+	resp.Body = resp.body
+
+	transferEncodings := resp.Headers.GetAllValues("Transfer-Encoding")
+	contentLengths := resp.Headers.GetAllValues("Content-Length")
+
+	switch {
+	case req.Method == common.Head || !bodyAllowedForStatus(resp.StatusCode) || resp.StatusCode == SWITCHING_PROTOCOLS:
+		resp.ContentLength = 0
+		resp.body.reader = NoBody
+
+	case req.Method == common.Connect && resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// The connection becomes a tunnel, what follows belongs to it.
+		resp.body.reader = reader
+		resp.body.closeDelimited = true
+
+	case len(transferEncodings) != 0:
+		if !resp.Headers.HasToken("Transfer-Encoding", "chunked") {
+			// Without the chunked coding the body ends with the connection.
+			resp.body.reader = reader
+			resp.body.closeDelimited = true
+			break
+		}
+
+		resp.Trailers = make(Headers)
+		resp.body.chunked = newChunkedBody(reader, resp.Trailers, opts)
+		resp.body.reader = resp.body.chunked
+
+	case len(contentLengths) != 0:
+		resp.ContentLength, err = parseContentLength(contentLengths)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", httperr.ErrInvalidResponse, err)
+		}
+		resp.body.reader = &fixedLengthBody{reader: reader, remaining: resp.ContentLength}
+
+	default:
+		resp.body.reader = reader
+		resp.body.closeDelimited = true
+	}
+
+	return resp, nil
+}
+
+/*
+Parses the status line of a response.
+
+	status-line = HTTP-version SP status-code SP [ reason-phrase ]
+*/
+func parseStatusLine(line string) (*HttpResponse, error) {
+	version, rest, found := strings.Cut(line, " ")
+	if !found || !httpVersionFormat.MatchString(version) {
+		return nil, fmt.Errorf("%w: status line %q", httperr.ErrInvalidResponse, line)
+	}
+
+	rawCode, reason, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(rawCode)
+	if err != nil || len(rawCode) != 3 || code < 100 {
+		return nil, fmt.Errorf("%w: status code %q", httperr.ErrInvalidResponse, rawCode)
+	}
+
+	resp := &HttpResponse{
+		StatusCode: common.StatusCode(code),
+		Reason:     reason,
+		Version:    version,
+		ProtoMajor: int(version[5] - '0'),
+		ProtoMinor: int(version[7] - '0'),
+	}
+
+	if resp.ProtoMajor != SUPPORTED_HTTP_MAJOR {
+		return nil, fmt.Errorf("%w: version %s", httperr.ErrInvalidResponse, version)
+	}
+
+	return resp, nil
+}
+
+/*
+Reports if the connection can serve another request once the body is read. Either side can ask to close it, HTTP/1.0 servers
+close it unless they agreed to keep it open, and a body delimited by the end of the connection uses it up.
+*/
+func (resp *HttpResponse) keepsAlive() bool {
+	if resp.body.closeDelimited || resp.StatusCode == SWITCHING_PROTOCOLS {
+		return false
+	}
+
+	if resp.Request.Headers.HasToken("Connection", "close") || resp.Headers.HasToken("Connection", "close") {
+		return false
+	}
+
+	if resp.ProtoMinor == 0 {
+		return resp.Headers.HasToken("Connection", "keep-alive")
+	}
+
+	return true
+}
+
+/*
+The body of a response read by the Client. It implements ResponseBodyReader.
+
+A body with a length or delimited by the end of the connection is returned whole by the first read, a chunked body one chunk
+per read. Once the body ends the read returns no data and io.EOF.
+*/
+type clientBody struct {
+	reader         io.Reader
+	chunked        *chunkedBody
+	closeDelimited bool // The body ends when the server closes the connection.
+	pc             *persistConn
+	reusable       bool               // The connection can go back to the pool once the body is read completely.
+	ctx            context.Context    // Stops the reading of the body when it ends.
+	stop           func() bool        // Stops watching the context. It reports false once the context ended the reading.
+	cancel         context.CancelFunc // Releases the timeout of the client once the body ends.
+	err            error              // Sticky error returned once the body is finished or broken.
+}
+
+func (b *clientBody) Read() (data []byte, err error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if b.chunked != nil {
+		data, err = b.chunked.readChunk(MAX_BODY_READ_BYTES)
+	} else {
+		data, err = io.ReadAll(io.LimitReader(b.reader, MAX_BODY_READ_BYTES))
+		if err == nil && len(data) == 0 {
+			err = io.EOF
+		}
+	}
+
+	if err != nil && err != io.EOF && b.ctx != nil && b.ctx.Err() != nil {
+		err = fmt.Errorf("%w: %v", b.ctx.Err(), err)
+	}
+
+	if err != nil {
+		b.finish(err)
+
+		// The end of the body is reported by the next read so the data is never returned along with an error.
+		if err == io.EOF && len(data) != 0 {
+			return data, nil
+		}
+	}
+
+	return
+}
+
+// Closes the connection unless the body was read completely, in which case it may go back to the pool.
+func (b *clientBody) Close() error {
+	b.finish(io.ErrClosedPipe)
+
+	return nil
+}
+
+// Ends the body with the error and releases the connection. It is only pooled when the body ended cleanly.
+func (b *clientBody) finish(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+
+	if b.cancel != nil {
+		defer b.cancel()
+	}
+
+	if b.pc == nil {
+		return
+	}
+
+	pc := b.pc
+	b.pc = nil
+
+	// A connection whose deadline was moved by the context can not be reused.
+	if b.stop != nil && !b.stop() {
+		b.reusable = false
+	}
+
+	if b.err == io.EOF && b.reusable {
+		pc.transport.putIdle(pc)
+		return
+	}
+
+	pc.transport.closeConn(pc)
+}
@@ -9,20 +9,46 @@ const CRLF = "\r\n"
 type HttpMethod string
 
 const (
-	Get    HttpMethod = "GET"
-	Post   HttpMethod = "POST"
-	Put    HttpMethod = "PUT"
-	Delete HttpMethod = "DELETE"
+	Get     HttpMethod = "GET"
+	Head    HttpMethod = "HEAD"
+	Post    HttpMethod = "POST"
+	Put     HttpMethod = "PUT"
+	Patch   HttpMethod = "PATCH"
+	Delete  HttpMethod = "DELETE"
+	Options HttpMethod = "OPTIONS"
+	Trace   HttpMethod = "TRACE"
+	Connect HttpMethod = "CONNECT"
 )
 
-// This function converts the key name to canonical name.
+// Reports if sending the request several times has the same effect as sending it once, so it can be retried safely.
+// Ref - https://www.rfc-editor.org/rfc/rfc9110#section-9.2.2
+func (m HttpMethod) IsIdempotent() bool {
+	switch m {
+	case Get, Head, Put, Delete, Options, Trace:
+		return true
+	}
+
+	return false
+}
+
+/*
+This function converts the key name to canonical name. The first letter of each dash separated part is upper cased and the rest
+lower cased, so every spelling of a field name maps to the same key.
+
+	content-length -> Content-Length
+	TE             -> Te
+	X-API-Key      -> X-Api-Key
+*/
 func GetCanonicalName(key string) (canonical string) {
 	key = strings.Trim(key, " ")
 	splitStr := strings.Split(key, "-")
 
-	// Convert the each key to upper case.
+	// Convert the first letter of each part to upper case and the rest to lower case since field names are case insensitive.
 	for index, stringPart := range splitStr {
-		stringPart = strings.ToUpper(string(stringPart[0])) + stringPart[1:]
+		if stringPart == "" {
+			continue
+		}
+		stringPart = strings.ToUpper(string(stringPart[0])) + strings.ToLower(stringPart[1:])
 		splitStr[index] = stringPart
 	}
 
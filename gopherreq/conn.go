@@ -0,0 +1,103 @@
+package gopherreq
+
+import (
+	"net"
+)
+
+// The state of a connection as seen by the shutdown logic.
+type connState int
+
+const (
+	stateNew    connState = iota // Accepted but no request has been read yet.
+	stateActive                  // A request is being read or handled.
+	stateIdle                    // Waiting for the next request on a persistent connection.
+)
+
+/*
+Records the state of the connection. It returns false if the connection was already closed by the shutdown and must not be used anymore.
+
+A new connection is refused once the shutdown started. The check is done under the lock so a connection accepted while the
+shutdown runs is either seen by it or never tracked at all, and the caller closes it.
+*/
+func (s *HttpServer) setConnState(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state == stateNew {
+		if s.shuttingDown() {
+			return false
+		}
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]connState)
+		}
+		s.conns[conn] = state
+		return true
+	}
+
+	if _, tracked := s.conns[conn]; !tracked {
+		return false
+	}
+
+	s.conns[conn] = state
+	return true
+}
+
+func (s *HttpServer) forgetConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+// Returns the number of connections which are reading or handling a request.
+func (s *HttpServer) ActiveConnections() int {
+	return s.countConns(stateActive)
+}
+
+// Returns the number of open connections which are waiting for a request.
+func (s *HttpServer) IdleConnections() int {
+	return s.countConns(stateNew) + s.countConns(stateIdle)
+}
+
+func (s *HttpServer) countConns(state connState) (count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, connState := range s.conns {
+		if connState == state {
+			count++
+		}
+	}
+
+	return
+}
+
+// Closes the connections which are not serving a request. It reports if there are no connections left.
+func (s *HttpServer) closeIdleConns() (allClosed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == stateActive {
+			continue
+		}
+
+		conn.Close()
+		delete(s.conns, conn)
+	}
+
+	return len(s.conns) == 0
+}
+
+// Closes every connection including the ones serving a request and cancels the context of their requests.
+func (s *HttpServer) closeAllConns() {
+	s.cancelRequests()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
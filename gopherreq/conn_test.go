@@ -0,0 +1,148 @@
+package gopherreq
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Polls the condition until it holds or a second passed.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Reads one response with a Content-Length framed body and returns its status line.
+func readResponseHead(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read status line: %v", err)
+	}
+
+	contentLength := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read header: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+
+		if value, found := strings.CutPrefix(strings.ToLower(line), "content-length:"); found {
+			contentLength, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				t.Fatalf("invalid Content-Length %q", value)
+			}
+		}
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, int64(contentLength)); err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+
+	return strings.TrimSpace(statusLine)
+}
+
+// Shutdown closes the idle connections at once and waits for the active ones to finish their request.
+func TestShutdownDrainsConnections(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	server := startTestServer(t, Config{
+		Handler: HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+			if req.URI.Path == "/slow" {
+				close(started)
+				<-release
+			}
+			w.Write([]byte("ok"))
+		}),
+	})
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", server.Addrs()[0].String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	// A keep-alive connection which already served its request and waits for the next one.
+	idleConn, idleReader := dial()
+	io.WriteString(idleConn, "GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if status := readResponseHead(t, idleReader); status != "HTTP/1.1 200 OK" {
+		t.Fatalf("idle connection got %q", status)
+	}
+
+	// A connection whose request is still being handled.
+	activeConn, activeReader := dial()
+	io.WriteString(activeConn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	waitFor(t, "one active and one idle connection", func() bool {
+		return server.ActiveConnections() == 1 && server.IdleConnections() == 1
+	})
+
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- server.Shutdown(context.Background())
+	}()
+
+	waitFor(t, "the idle connection to be closed", func() bool {
+		return server.IdleConnections() == 0
+	})
+
+	if _, err := idleReader.ReadByte(); err != io.EOF {
+		t.Fatalf("idle connection read returned %v, want io.EOF", err)
+	}
+
+	if active := server.ActiveConnections(); active != 1 {
+		t.Fatalf("ActiveConnections during the drain = %d, want 1", active)
+	}
+
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("Shutdown returned %v before the active request finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	if status := readResponseHead(t, activeReader); status != "HTTP/1.1 200 OK" {
+		t.Fatalf("active connection got %q", status)
+	}
+
+	if _, err := activeReader.ReadByte(); err != io.EOF {
+		t.Fatalf("active connection read after its response returned %v, want io.EOF", err)
+	}
+
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return after the active request finished")
+	}
+
+	if active, idle := server.ActiveConnections(), server.IdleConnections(); active != 0 || idle != 0 {
+		t.Fatalf("connections after Shutdown = %d active, %d idle, want none", active, idle)
+	}
+}
@@ -0,0 +1,226 @@
+package cookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignatureInvalid = errors.New("cookie signature is invalid")
+	ErrDecryptionFailed = errors.New("cookie could not be decrypted")
+	ErrCookieExpired    = errors.New("cookie has expired")
+	ErrInvalidKey       = errors.New("invalid cookie codec key")
+)
+
+/*
+Codec protects the value of a cookie from the client.
+
+Encode returns the value to send in the cookie and Decode returns the original value, or an error when the value was changed or
+has expired. The name of the cookie is bound to the value so a value can not be moved to another cookie.
+*/
+type Codec interface {
+	Encode(name string, value string) (string, error)
+	Decode(name string, encoded string) (string, error)
+}
+
+// The cookie values only use the characters of the URL safe base64 alphabet and the "." separator.
+var codecEncoding = base64.RawURLEncoding
+
+/*
+SignedCodec signs the values with HMAC-SHA256. The value is still readable by the client but it can not be changed.
+
+The first key signs the new values and every key is accepted when verifying, so keys can be rotated by adding the new key at the
+front and removing the old one once the cookies signed with it have expired.
+
+Format - base64(value) "." timestamp "." base64(signature)
+*/
+type SignedCodec struct {
+	keys   [][]byte
+	maxAge time.Duration // The values older than this are rejected. Zero disables the check.
+	now    func() time.Time
+}
+
+func NewSignedCodec(maxAge time.Duration, keys ...[]byte) (*SignedCodec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: at least one key is required", ErrInvalidKey)
+	}
+
+	for _, key := range keys {
+		// Shorter keys make the signature easy to brute force.
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%w: signing keys must be at least 32 bytes", ErrInvalidKey)
+		}
+	}
+
+	return &SignedCodec{keys: keys, maxAge: maxAge, now: time.Now}, nil
+}
+
+func (s *SignedCodec) Encode(name string, value string) (string, error) {
+	payload := codecEncoding.EncodeToString([]byte(value)) + "." + strconv.FormatInt(s.now().Unix(), 10)
+	signature := sign(s.keys[0], name, payload)
+
+	return payload + "." + codecEncoding.EncodeToString(signature), nil
+}
+
+func (s *SignedCodec) Decode(name string, encoded string) (string, error) {
+	index := strings.LastIndexByte(encoded, '.')
+	if index == -1 {
+		return "", ErrSignatureInvalid
+	}
+
+	payload := encoded[:index]
+	signature, err := codecEncoding.DecodeString(encoded[index+1:])
+	if err != nil {
+		return "", ErrSignatureInvalid
+	}
+
+	verified := false
+	for _, key := range s.keys {
+		if hmac.Equal(signature, sign(key, name, payload)) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return "", ErrSignatureInvalid
+	}
+
+	rawValue, rawTimestamp, found := strings.Cut(payload, ".")
+	if !found {
+		return "", ErrSignatureInvalid
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return "", ErrSignatureInvalid
+	}
+
+	err = checkAge(timestamp, s.maxAge, s.now())
+	if err != nil {
+		return "", err
+	}
+
+	value, err := codecEncoding.DecodeString(rawValue)
+	if err != nil {
+		return "", ErrSignatureInvalid
+	}
+
+	return string(value), nil
+}
+
+// The name is part of the signed message so the value of one cookie is not valid for another.
+func sign(key []byte, name string, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'|'})
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+/*
+EncryptedCodec encrypts the values with AES-GCM so the client can neither read nor change them.
+
+The keys must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256. Like SignedCodec the first key encrypts and every
+key is tried when decrypting.
+
+Format - base64(nonce ciphertext), where the plaintext is an 8 byte timestamp followed by the value.
+*/
+type EncryptedCodec struct {
+	aeads  []cipher.AEAD
+	maxAge time.Duration // The values older than this are rejected. Zero disables the check.
+	now    func() time.Time
+}
+
+func NewEncryptedCodec(maxAge time.Duration, keys ...[]byte) (*EncryptedCodec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: at least one key is required", ErrInvalidKey)
+	}
+
+	aeads := make([]cipher.AEAD, 0, len(keys))
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+
+		aeads = append(aeads, aead)
+	}
+
+	return &EncryptedCodec{aeads: aeads, maxAge: maxAge, now: time.Now}, nil
+}
+
+func (e *EncryptedCodec) Encode(name string, value string) (string, error) {
+	aead := e.aeads[0]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+8+len(value)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := binary.BigEndian.AppendUint64(nil, uint64(e.now().Unix()))
+	plaintext = append(plaintext, value...)
+
+	// The name is the additional data so it is authenticated without being stored in the value.
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(name))
+
+	return codecEncoding.EncodeToString(sealed), nil
+}
+
+func (e *EncryptedCodec) Decode(name string, encoded string) (string, error) {
+	sealed, err := codecEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrDecryptionFailed
+	}
+
+	for _, aead := range e.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil || len(plaintext) < 8 {
+			continue
+		}
+
+		err = checkAge(int64(binary.BigEndian.Uint64(plaintext)), e.maxAge, e.now())
+		if err != nil {
+			return "", err
+		}
+
+		return string(plaintext[8:]), nil
+	}
+
+	return "", ErrDecryptionFailed
+}
+
+func checkAge(timestamp int64, maxAge time.Duration, now time.Time) error {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	if now.Sub(time.Unix(timestamp, 0)) > maxAge {
+		return ErrCookieExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+package cookie
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oldKey = []byte("0123456789abcdef0123456789abcdef")
+	newKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func newSigned(t *testing.T, maxAge time.Duration, keys ...[]byte) *SignedCodec {
+	t.Helper()
+
+	codec, err := NewSignedCodec(maxAge, keys...)
+	if err != nil {
+		t.Fatalf("NewSignedCodec: %v", err)
+	}
+
+	return codec
+}
+
+func newEncrypted(t *testing.T, maxAge time.Duration, keys ...[]byte) *EncryptedCodec {
+	t.Helper()
+
+	codec, err := NewEncryptedCodec(maxAge, keys...)
+	if err != nil {
+		t.Fatalf("NewEncryptedCodec: %v", err)
+	}
+
+	return codec
+}
+
+func encode(t *testing.T, codec Codec, name string, value string) string {
+	t.Helper()
+
+	encoded, err := codec.Encode(name, value)
+	if err != nil {
+		t.Fatalf("Encode(%q, %q): %v", name, value, err)
+	}
+
+	return encoded
+}
+
+// Changes the first character, whose 6 bits are all part of the decoded value unlike those of the last one.
+func flipFirst(encoded string) string {
+	if encoded[0] == 'A' {
+		return "B" + encoded[1:]
+	}
+
+	return "A" + encoded[1:]
+}
+
+func TestCodecKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		signed bool
+		keys   [][]byte
+		valid  bool
+	}{
+		{"signed without key", true, nil, false},
+		{"signed short key", true, [][]byte{[]byte("short")}, false},
+		{"signed short old key", true, [][]byte{newKey, oldKey[:31]}, false},
+		{"signed", true, [][]byte{newKey, oldKey}, true},
+		{"encrypted without key", false, nil, false},
+		{"encrypted 20 byte key", false, [][]byte{oldKey[:20]}, false},
+		{"encrypted AES-128", false, [][]byte{oldKey[:16]}, true},
+		{"encrypted AES-192", false, [][]byte{oldKey[:24]}, true},
+		{"encrypted AES-256", false, [][]byte{newKey, oldKey}, true},
+	}
+
+	for _, test := range tests {
+		var err error
+		if test.signed {
+			_, err = NewSignedCodec(0, test.keys...)
+		} else {
+			_, err = NewEncryptedCodec(0, test.keys...)
+		}
+
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s: error = %v, want ErrInvalidKey", test.name, err)
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := map[string]Codec{
+		"signed":    newSigned(t, time.Hour, oldKey),
+		"encrypted": newEncrypted(t, time.Hour, oldKey),
+	}
+
+	for name, codec := range codecs {
+		for _, value := range []string{"", "abc", "user=42; role=admin", "café \x00\xff"} {
+			encoded := encode(t, codec, "session", value)
+
+			// The encoded value has to be a valid cookie value.
+			if !isValidValue(encoded) || strings.ContainsAny(encoded, " ,") {
+				t.Errorf("%s: Encode(%q) = %q, not a cookie value", name, value, encoded)
+			}
+
+			decoded, err := codec.Decode("session", encoded)
+			if err != nil || decoded != value {
+				t.Errorf("%s: Decode(Encode(%q)) = %q, %v", name, value, decoded, err)
+			}
+		}
+	}
+}
+
+// Any change to the value, the timestamp or the signature is rejected.
+func TestSignedCodecTampering(t *testing.T) {
+	codec := newSigned(t, 0, oldKey)
+	encoded := encode(t, codec, "session", "user=42")
+
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Encode = %q, want 3 parts", encoded)
+	}
+	value, timestamp, signature := parts[0], parts[1], parts[2]
+
+	forged := newSigned(t, 0, newKey)
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"value changed", codecEncoding.EncodeToString([]byte("user=1")) + "." + timestamp + "." + signature},
+		{"timestamp changed", value + "." + timestamp + "0." + signature},
+		{"signature changed", value + "." + timestamp + "." + flipFirst(signature)},
+		{"signature cut", value + "." + timestamp + "." + signature[:len(signature)-2]},
+		{"signature removed", value + "." + timestamp},
+		{"signature not base64", value + "." + timestamp + ".!!"},
+		{"value with separator", value + ".x." + timestamp + "." + signature},
+		{"signed with another key", encode(t, forged, "session", "user=42")},
+		{"empty", ""},
+		{"no separator", "abc"},
+	}
+
+	for _, test := range tests {
+		if test.encoded == encoded {
+			t.Fatalf("%s: the value was not changed", test.name)
+		}
+
+		if decoded, err := codec.Decode("session", test.encoded); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: Decode = %q, %v, want ErrSignatureInvalid", test.name, decoded, err)
+		}
+	}
+}
+
+func TestEncryptedCodecTampering(t *testing.T) {
+	codec := newEncrypted(t, 0, oldKey)
+	encoded := encode(t, codec, "session", "user=42")
+
+	sealed, err := codecEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("Encode = %q: %v", encoded, err)
+	}
+
+	// Every byte of the nonce, the ciphertext and the tag is covered.
+	for index := range sealed {
+		changed := append([]byte(nil), sealed...)
+		changed[index] ^= 1
+
+		if decoded, err := codec.Decode("session", codecEncoding.EncodeToString(changed)); !errors.Is(err, ErrDecryptionFailed) {
+			t.Fatalf("byte %d flipped: Decode = %q, %v, want ErrDecryptionFailed", index, decoded, err)
+		}
+	}
+
+	forged := newEncrypted(t, 0, newKey)
+
+	for name, value := range map[string]string{
+		"truncated":                  codecEncoding.EncodeToString(sealed[:len(sealed)-1]),
+		"shorter than the nonce":     codecEncoding.EncodeToString(sealed[:8]),
+		"not base64":                 encoded + "!",
+		"empty":                      "",
+		"encrypted with another key": encode(t, forged, "session", "user=42"),
+	} {
+		if decoded, err := codec.Decode("session", value); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("%s: Decode = %q, %v, want ErrDecryptionFailed", name, decoded, err)
+		}
+	}
+
+	// The nonce is random so the same value is never encoded twice the same way.
+	if encode(t, codec, "session", "user=42") == encoded {
+		t.Error("Encode returned the same value twice")
+	}
+}
+
+/*
+The keys are rotated by adding the new key at the front. The values of the old key are still read, the new values use the new
+key and the old values are rejected once the old key is removed.
+*/
+func TestCodecKeyRotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   func(t *testing.T, keys ...[]byte) Codec
+		invalid error
+	}{
+		{"signed", func(t *testing.T, keys ...[]byte) Codec { return newSigned(t, 0, keys...) }, ErrSignatureInvalid},
+		{"encrypted", func(t *testing.T, keys ...[]byte) Codec { return newEncrypted(t, 0, keys...) }, ErrDecryptionFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := test.codec(t, oldKey)
+			during := test.codec(t, newKey, oldKey)
+			after := test.codec(t, newKey)
+
+			oldValue := encode(t, before, "session", "old")
+			if decoded, err := during.Decode("session", oldValue); err != nil || decoded != "old" {
+				t.Fatalf("old value with both keys = %q, %v, want old", decoded, err)
+			}
+
+			newValue := encode(t, during, "session", "new")
+			for name, codec := range map[string]Codec{"new key": after, "both keys": during} {
+				if decoded, err := codec.Decode("session", newValue); err != nil || decoded != "new" {
+					t.Fatalf("new value with the %s = %q, %v, want new", name, decoded, err)
+				}
+			}
+
+			// The new value was encoded with the first key only.
+			if _, err := before.Decode("session", newValue); !errors.Is(err, test.invalid) {
+				t.Fatalf("new value with the old key = %v, want %v", err, test.invalid)
+			}
+
+			if _, err := after.Decode("session", oldValue); !errors.Is(err, test.invalid) {
+				t.Fatalf("old value after the rotation = %v, want %v", err, test.invalid)
+			}
+		})
+	}
+}
+
+// A value is bound to the name of its cookie and can not be replayed in another one.
+func TestCodecWrongName(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   Codec
+		invalid error
+	}{
+		{"signed", newSigned(t, 0, oldKey), ErrSignatureInvalid},
+		{"encrypted", newEncrypted(t, 0, oldKey), ErrDecryptionFailed},
+	}
+
+	for _, test := range tests {
+		encoded := encode(t, test.codec, "role", "admin")
+
+		for _, name := range []string{"session", "Role", "role ", "rol", ""} {
+			if decoded, err := test.codec.Decode(name, encoded); !errors.Is(err, test.invalid) {
+				t.Errorf("%s: Decode(%q) = %q, %v, want %v", test.name, name, decoded, err, test.invalid)
+			}
+		}
+
+	}
+}
+
+func TestCodecExpiry(t *testing.T) {
+	issued := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	signed := newSigned(t, time.Hour, oldKey)
+	encrypted := newEncrypted(t, time.Hour, oldKey)
+	unlimitedSigned := newSigned(t, 0, oldKey)
+	unlimitedEncrypted := newEncrypted(t, 0, oldKey)
+
+	setNow := func(now time.Time) {
+		clock := func() time.Time { return now }
+		signed.now, encrypted.now = clock, clock
+		unlimitedSigned.now, unlimitedEncrypted.now = clock, clock
+	}
+
+	tests := []struct {
+		age     time.Duration
+		expired bool
+	}{
+		{0, false},
+		{30 * time.Minute, false},
+		{time.Hour, false},
+		{time.Hour + time.Second, true},
+		{24 * time.Hour, true},
+		{-time.Hour, false}, // A clock which went back is not an expiry.
+	}
+
+	codecs := map[string]Codec{
+		"signed":    signed,
+		"encrypted": encrypted,
+	}
+	unlimited := map[string]Codec{
+		"signed":    unlimitedSigned,
+		"encrypted": unlimitedEncrypted,
+	}
+
+	for name, codec := range codecs {
+		setNow(issued)
+		encoded := encode(t, codec, "session", "user=42")
+		unlimitedEncoded := encode(t, unlimited[name], "session", "user=42")
+
+		for _, test := range tests {
+			setNow(issued.Add(test.age))
+
+			decoded, err := codec.Decode("session", encoded)
+			if test.expired && !errors.Is(err, ErrCookieExpired) {
+				t.Errorf("%s: Decode after %v = %q, %v, want ErrCookieExpired", name, test.age, decoded, err)
+			}
+			if !test.expired && (err != nil || decoded != "user=42") {
+				t.Errorf("%s: Decode after %v = %q, %v, want user=42", name, test.age, decoded, err)
+			}
+
+			// A codec without maximum age accepts the values of any age.
+			if decoded, err := unlimited[name].Decode("session", unlimitedEncoded); err != nil || decoded != "user=42" {
+				t.Errorf("%s: Decode without maximum age after %v = %q, %v", name, test.age, decoded, err)
+			}
+		}
+	}
+}
+
+func TestGetVerified(t *testing.T) {
+	codec := newSigned(t, 0, oldKey)
+
+	list := NewCookieList()
+	list.Add(Cookie{Name: "session", Value: encode(t, codec, "session", "user=42")})
+	list.Add(Cookie{Name: "moved", Value: encode(t, codec, "session", "user=42")})
+	list.Add(Cookie{Name: "plain", Value: "user=42"})
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{"session", "user=42", nil},
+		{"moved", "", ErrSignatureInvalid},
+		{"plain", "", ErrSignatureInvalid},
+		{"missing", "", ErrCookieNotFound},
+	}
+
+	for _, test := range tests {
+		value, err := list.GetVerified(test.name, codec)
+		if value != test.value || !errors.Is(err, test.err) {
+			t.Errorf("GetVerified(%q) = %q, %v, want %q, %v", test.name, value, err, test.value, test.err)
+		}
+	}
+}
@@ -5,26 +5,32 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 )
 
 type Cookie struct {
-	Name     string
-	Value    string
-	Path     string
-	Domain   string
-	Expires  time.Time
-	MaxAge   int
-	Secure   bool
-	HttpOnly bool
-	SameSite SameSite
-	Raw      string
-	Unparsed []string // Raw text of unparsed attribute-value pairs
+	Name        string
+	Value       string
+	Path        string
+	Domain      string
+	Expires     time.Time
+	MaxAge      int // Zero means the attribute is not set. A negative value asks the client to delete the cookie now and is sent as Max-Age=0.
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool // Stores the cookie in a jar partitioned by the top level site (CHIPS). It requires Secure.
+	Raw         string
+	Unparsed    []string // Raw text of unparsed attribute-value pairs
 }
 
+// CookieList holds the cookies of a request by name. It is safe for concurrent use when created with NewCookieList.
 type CookieList struct {
+	mu      *sync.RWMutex
 	cookies map[string]Cookie
 }
 
@@ -48,6 +54,10 @@ var (
 	ErrInvalidMaxAge         = errors.New("invalid cookie max-age")
 	ErrInvalidSameSite       = errors.New("invalid cookie same-site attribute")
 	ErrSecureRequiredForNone = errors.New("secure flag required when SameSite=None")
+
+	ErrSecureRequiredForPartitioned = errors.New("secure flag required for partitioned cookies")
+	ErrInvalidPrefix                = errors.New("cookie does not satisfy the requirements of its name prefix")
+	ErrCookieNotFound               = errors.New("cookie not found")
 )
 
 /*
@@ -74,32 +84,63 @@ func (s SameSite) String() string {
 	return "Unknown"
 }
 
+// The date format of the Expires attribute. Ref - https://www.rfc-editor.org/rfc/rfc9110#section-5.6.7
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+/*
+String serializes the cookie as the value of a Set-Cookie header.
+
+	name=value; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=Lax; Partitioned
+
+Values containing a space or a comma are quoted. SameSiteDefaultMode leaves the attribute out so the browser default applies.
+Ref - https://datatracker.ietf.org/doc/html/draft-ietf-httpbis-rfc6265bis#section-4.1
+*/
 func (c Cookie) String() string {
-	s := fmt.Sprintf("%s=%s;", c.Name, c.Value)
+	builder := strings.Builder{}
+
+	builder.WriteString(c.Name)
+	builder.WriteByte('=')
+	builder.WriteString(quoteValue(c.Value))
 
 	if c.Path != "" {
-		s += fmt.Sprintf(" Path=%s;", c.Path)
+		builder.WriteString("; Path=" + c.Path)
 	}
 	if c.Domain != "" {
-		s += fmt.Sprintf(" Domain=%s;", c.Domain)
+		// A leading dot is ignored by clients so it is not sent.
+		builder.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
 	}
 	if !c.Expires.IsZero() {
-		s += fmt.Sprintf(" Expires=%s;", c.Expires.UTC().Format(time.RFC1123))
+		builder.WriteString("; Expires=" + c.Expires.UTC().Format(expiresFormat))
 	}
 	if c.MaxAge > 0 {
-		s += fmt.Sprintf(" Max-Age=%d;", c.MaxAge)
+		builder.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		builder.WriteString("; Max-Age=0")
 	}
 	if c.HttpOnly {
-		s += " HttpOnly;"
+		builder.WriteString("; HttpOnly")
 	}
 	if c.Secure {
-		s += " Secure;"
+		builder.WriteString("; Secure")
 	}
-	if c.SameSite > 0 {
-		s += fmt.Sprintf(" SameSite=%s;", c.SameSite)
+	switch c.SameSite {
+	case SameSiteLaxMode, SameSiteStrictMode, SameSiteNoneMode:
+		builder.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		builder.WriteString("; Partitioned")
 	}
 
-	return s
+	return builder.String()
+}
+
+// Wraps the value in double quotes when it contains a space or a comma which are not allowed in a bare cookie value.
+func quoteValue(value string) string {
+	if strings.ContainsAny(value, " ,") {
+		return `"` + value + `"`
+	}
+
+	return value
 }
 
 // isValidName checks if the cookie name follows RFC 6265 specs
@@ -109,20 +150,31 @@ func isValidName(name string) bool {
 	}
 
 	return strings.IndexFunc(name, func(r rune) bool {
-		// Cookie names must not contain separator characters
-		return unicode.IsSpace(r) || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r)
+		// Cookie names must not contain separator or control characters
+		return unicode.IsSpace(r) || r < ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r)
 	}) < 0
 }
 
-// isValidValue checks if the cookie value follows RFC 6265 specs
+/*
+isValidValue checks if the cookie value follows RFC 6265 specs
+
+	cookie-value = *cookie-octet / ( DQUOTE *cookie-octet DQUOTE )
+	cookie-octet = %x21 / %x23-2B / %x2D-3A / %x3C-5B / %x5D-7E
+
+Spaces and commas are accepted as well since the value is quoted when serialized.
+*/
 func isValidValue(value string) bool {
 	if value == "" {
 		return true // Empty values are allowed
 	}
 
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
 	return strings.IndexFunc(value, func(r rune) bool {
-		// Cookie values must not contain separator characters or whitespace
-		return r <= ' ' || r > '~' || strings.ContainsRune("(),/\\?@:;\"=", r)
+		// Cookie values must not contain control characters, quotes, semicolons or backslashes
+		return r < ' ' || r > '~' || r == '"' || r == ';' || r == '\\'
 	}) < 0
 }
 
@@ -177,8 +229,9 @@ func (c *Cookie) Validate() error {
 		return fmt.Errorf("%w: %s", ErrInvalidPath, c.Path)
 	}
 
+	// The zero value means the attribute is not set.
 	switch c.SameSite {
-	case SameSiteDefaultMode, SameSiteLaxMode, SameSiteStrictMode, SameSiteNoneMode:
+	case 0, SameSiteDefaultMode, SameSiteLaxMode, SameSiteStrictMode, SameSiteNoneMode:
 
 	default:
 		return fmt.Errorf("%w: %d", ErrInvalidSameSite, c.SameSite)
@@ -188,6 +241,14 @@ func (c *Cookie) Validate() error {
 		return ErrSecureRequiredForNone
 	}
 
+	if c.Partitioned && !c.Secure {
+		return ErrSecureRequiredForPartitioned
+	}
+
+	if !c.satisfiesPrefix() {
+		return fmt.Errorf("%w: %s", ErrInvalidPrefix, c.Name)
+	}
+
 	if strings.Contains(c.Value, "http://") || strings.Contains(c.Value, "https://") {
 		_, err := url.Parse(c.Value)
 		if err != nil {
@@ -197,6 +258,27 @@ func (c *Cookie) Validate() error {
 
 	return nil
 }
+
+/*
+Checks the rules of the cookie name prefixes.
+
+	__Secure- : The cookie must be Secure.
+	__Host-   : The cookie must be Secure, have the path "/" and no domain so it is bound to the host which set it.
+
+Ref - https://datatracker.ietf.org/doc/html/draft-ietf-httpbis-rfc6265bis#section-4.1.3
+*/
+func (c *Cookie) satisfiesPrefix() bool {
+	if strings.HasPrefix(c.Name, "__Secure-") {
+		return c.Secure
+	}
+
+	if strings.HasPrefix(c.Name, "__Host-") {
+		return c.Secure && c.Path == "/" && c.Domain == ""
+	}
+
+	return true
+}
+
 func ParseRequestCookie(cookie string) (c Cookie, err error) {
 
 	splits := strings.SplitN(cookie, "=", 2)
@@ -206,11 +288,24 @@ func ParseRequestCookie(cookie string) (c Cookie, err error) {
 		return
 	}
 
-	isSecure := strings.HasPrefix(splits[0], "__Secure")
+	// The pairs in the header are separated by "; " so the space is not part of the name.
+	name := strings.TrimSpace(splits[0])
+	value := strings.TrimSpace(splits[1])
+
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	if !isValidName(name) {
+		err = ErrInvalidName
+		return
+	}
+
+	isSecure := strings.HasPrefix(name, "__Secure")
 
 	c = Cookie{
-		Name:     splits[0],
-		Value:    splits[1],
+		Name:     name,
+		Value:    value,
 		Unparsed: splits,
 		Secure:   isSecure,
 	}
@@ -221,13 +316,42 @@ func ParseRequestCookie(cookie string) (c Cookie, err error) {
 
 func NewCookieList() CookieList {
 	c := CookieList{
+		mu:      &sync.RWMutex{},
 		cookies: make(map[string]Cookie),
 	}
 
 	return c
 }
 
+// The zero CookieList has no lock. It is only safe to use from a single goroutine.
+func (l *CookieList) lock() {
+	if l.mu != nil {
+		l.mu.Lock()
+	}
+}
+
+func (l *CookieList) unlock() {
+	if l.mu != nil {
+		l.mu.Unlock()
+	}
+}
+
+func (l *CookieList) rlock() {
+	if l.mu != nil {
+		l.mu.RLock()
+	}
+}
+
+func (l *CookieList) runlock() {
+	if l.mu != nil {
+		l.mu.RUnlock()
+	}
+}
+
 func (l *CookieList) Get(key string) (value Cookie, exists bool) {
+	l.rlock()
+	defer l.runlock()
+
 	value = l.cookies[key]
 	exists = len(value.Name) != 0
 
@@ -235,11 +359,63 @@ func (l *CookieList) Get(key string) (value Cookie, exists bool) {
 
 }
 
+// Adds the cookie. It replaces the existing cookie with the same name.
 func (l *CookieList) Add(c Cookie) {
+	l.lock()
+	defer l.unlock()
+
+	if l.cookies == nil {
+		l.cookies = make(map[string]Cookie)
+	}
+
 	l.cookies[c.Name] = c
 
 }
 
+// Adds the cookie or replaces the one with the same name.
+func (l *CookieList) Set(c Cookie) {
+	l.Add(c)
+}
+
+func (l *CookieList) Remove(key string) {
+	l.lock()
+	defer l.unlock()
+
+	delete(l.cookies, key)
+}
+
+// Returns every cookie sorted by name.
+func (l *CookieList) All() (cookies []Cookie) {
+	l.rlock()
+	defer l.runlock()
+
+	cookies = make([]Cookie, 0, len(l.cookies))
+	for _, c := range l.cookies {
+		cookies = append(cookies, c)
+	}
+
+	slices.SortFunc(cookies, func(a Cookie, b Cookie) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return
+}
+
+/*
+GetVerified decodes the value of the cookie with the codec.
+
+It returns ErrCookieNotFound when the cookie was not sent, ErrSignatureInvalid or ErrDecryptionFailed when the value was changed
+and ErrCookieExpired when the value is older than the maximum age of the codec.
+*/
+func (l *CookieList) GetVerified(name string, codec Codec) (string, error) {
+	c, exists := l.Get(name)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", ErrCookieNotFound, name)
+	}
+
+	return codec.Decode(name, c.Value)
+}
+
 func (l *CookieList) Exists(key string) (exists bool) {
 
 	_, exists = l.Get(key)
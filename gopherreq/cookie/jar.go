@@ -0,0 +1,460 @@
+package cookie
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Jar stores the cookies received by a client and picks the ones to send with each request following the RFC 6265 storage model.
+
+Cookies are keyed by domain, path and name. Expired cookies are never returned and are dropped on the next write. It implements
+CookieManager and is safe for concurrent use. The zero value is an empty jar ready to use.
+Ref - https://www.rfc-editor.org/rfc/rfc6265#section-5.3
+*/
+type Jar struct {
+	mu      sync.Mutex
+	entries map[jarKey]*jarEntry
+	now     func() time.Time // Replaced to control the clock. Nil means time.Now.
+}
+
+type jarKey struct {
+	domain string
+	path   string
+	name   string
+}
+
+/*
+A stored cookie with the state the storage model needs.
+
+The Domain of a host-only cookie stays empty like it was received, so the rules of the __Host- prefix still hold for it. The
+host it belongs to is kept aside.
+*/
+type jarEntry struct {
+	Cookie   Cookie    `json:"cookie"`
+	Host     string    `json:"host"`      // The domain of the cookie, or the host which set it for the host-only cookies.
+	HostOnly bool      `json:"host_only"` // The cookie is only sent to the exact host which set it.
+	Expiry   time.Time `json:"expiry"`    // Zero for the session cookies.
+	Created  time.Time `json:"created"`
+}
+
+var _ CookieManager = (*Jar)(nil)
+
+func NewJar() *Jar {
+	return &Jar{
+		entries: make(map[jarKey]*jarEntry),
+		now:     time.Now,
+	}
+}
+
+/*
+SetCookies stores the cookies received in the response for the url.
+
+Cookies are rejected when their domain does not match the host, when a Secure cookie comes from an insecure scheme or when they
+break the rules of the __Secure- and __Host- prefixes. A cookie with a Max-Age of zero or less, or an Expires in the past,
+deletes the stored one.
+*/
+func (j *Jar) SetCookies(u *url.URL, cookies []Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.clock()
+	host := canonicalHost(u)
+	secureOrigin := isSecureScheme(u.Scheme)
+
+	for _, c := range cookies {
+		entry, ok := j.newEntry(c, host, u.Path, secureOrigin, now)
+		if !ok {
+			continue
+		}
+
+		j.store(entry, now)
+	}
+}
+
+// Returns the cookies to send with a request to the url. The ones with longer paths come first, then the oldest ones.
+func (j *Jar) Cookies(u *url.URL) (cookies []Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.clock()
+	host := canonicalHost(u)
+	secureOrigin := isSecureScheme(u.Scheme)
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	matched := []*jarEntry{}
+	for key, entry := range j.entries {
+		if entry.expired(now) {
+			delete(j.entries, key)
+			continue
+		}
+
+		if entry.HostOnly && host != entry.Host {
+			continue
+		}
+		if !entry.HostOnly && !domainMatch(host, entry.Host) {
+			continue
+		}
+		if !pathMatch(path, entry.Cookie.Path) {
+			continue
+		}
+		if entry.Cookie.Secure && !secureOrigin {
+			continue
+		}
+
+		matched = append(matched, entry)
+	}
+
+	slices.SortFunc(matched, func(a *jarEntry, b *jarEntry) int {
+		if len(a.Cookie.Path) != len(b.Cookie.Path) {
+			return len(b.Cookie.Path) - len(a.Cookie.Path)
+		}
+		return a.Created.Compare(b.Created)
+	})
+
+	for _, entry := range matched {
+		cookies = append(cookies, Cookie{Name: entry.Cookie.Name, Value: entry.Cookie.Value})
+	}
+
+	return
+}
+
+// Returns the stored cookie with the name. If several domains or paths hold one, the one with the longest path is returned.
+func (j *Jar) Get(name string) (c Cookie, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.clock()
+	var found *jarEntry
+
+	for _, entry := range j.entries {
+		if entry.Cookie.Name != name || entry.expired(now) {
+			continue
+		}
+
+		if found == nil || len(entry.Cookie.Path) > len(found.Cookie.Path) {
+			found = entry
+		}
+	}
+
+	if found == nil {
+		return Cookie{}, fmt.Errorf("%w: %s", ErrCookieNotFound, name)
+	}
+
+	return found.Cookie, nil
+}
+
+// Stores the cookie for its domain and path. It replaces the cookie with the same domain, path and name.
+func (j *Jar) Add(c Cookie) error {
+	return j.Set(c)
+}
+
+/*
+Set stores a cookie which was not received from a server, like one loaded from a configuration.
+
+The domain is required since there is no request host to default to and the path defaults to "/". The cookie is sent to the
+domain and all its sub domains. Host-only cookies, which the __Host- ones must be, are stored with SetForHost.
+*/
+func (j *Jar) Set(c Cookie) error {
+	if c.Domain == "" {
+		return fmt.Errorf("%w: domain is required, use SetForHost for host-only cookies", ErrInvalidDomain)
+	}
+
+	c.Domain = strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+
+	return j.set(c, c.Domain, false)
+}
+
+/*
+SetForHost stores a cookie which was not received from a server for the host.
+
+A cookie without a domain is host-only, it is only sent to that exact host. It is the only way to store a __Host- cookie since
+the prefix forbids the domain. A cookie with a domain must cover the host and is stored like with Set.
+*/
+func (j *Jar) SetForHost(host string, c Cookie) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return fmt.Errorf("%w: host is required", ErrInvalidDomain)
+	}
+
+	if c.Domain == "" {
+		return j.set(c, host, true)
+	}
+
+	c.Domain = strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	if !domainMatch(host, c.Domain) {
+		return fmt.Errorf("%w: %s does not cover %s", ErrInvalidDomain, c.Domain, host)
+	}
+
+	return j.set(c, c.Domain, false)
+}
+
+func (j *Jar) set(c Cookie, host string, hostOnly bool) error {
+	if c.Path == "" {
+		c.Path = "/"
+	}
+
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.clock()
+	j.store(&jarEntry{
+		Cookie:   c,
+		Host:     host,
+		HostOnly: hostOnly,
+		Expiry:   expiryOf(c, now),
+		Created:  now,
+	}, now)
+
+	return nil
+}
+
+// Removes the cookie stored for the domain, path and name.
+func (j *Jar) Remove(domain string, path string, name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.entries, jarKey{domain: strings.ToLower(strings.TrimPrefix(domain, ".")), path: path, name: name})
+}
+
+// Returns every cookie which has not expired, ordered by domain, path and name.
+func (j *Jar) All() (cookies []Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.clock()
+	entries := []*jarEntry{}
+	for key, entry := range j.entries {
+		if entry.expired(now) {
+			delete(j.entries, key)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a *jarEntry, b *jarEntry) int {
+		return strings.Compare(a.Host+" "+a.Cookie.Path+" "+a.Cookie.Name, b.Host+" "+b.Cookie.Path+" "+b.Cookie.Name)
+	})
+
+	for _, entry := range entries {
+		cookies = append(cookies, entry.Cookie)
+	}
+
+	return
+}
+
+// Writes the persistent cookies to the file as JSON. Session cookies end with the process and are not saved.
+func (j *Jar) Save(path string) error {
+	j.mu.Lock()
+
+	now := j.clock()
+	entries := []*jarEntry{}
+	for _, entry := range j.entries {
+		if entry.Expiry.IsZero() || entry.expired(now) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	j.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Cookies often hold credentials so the file is only readable by its owner.
+	temporary := path + ".tmp"
+	err = os.WriteFile(temporary, data, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path)
+}
+
+// Adds the cookies saved in the file to the jar. Expired cookies are skipped. A missing file is not an error.
+func (j *Jar) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entries := []*jarEntry{}
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.clock()
+	for _, entry := range entries {
+		// A null element of the list decodes to nil.
+		if entry == nil {
+			continue
+		}
+
+		// The files saved before the host was kept aside hold it in the domain of the host-only cookies.
+		if entry.Host == "" {
+			entry.Host = entry.Cookie.Domain
+			if entry.HostOnly {
+				entry.Cookie.Domain = ""
+			}
+		}
+
+		if entry.Host == "" || entry.expired(now) || entry.Cookie.Validate() != nil {
+			continue
+		}
+		j.store(entry, now)
+	}
+
+	return nil
+}
+
+// Builds the entry for a cookie received from host. It reports false when the cookie must be ignored.
+func (j *Jar) newEntry(c Cookie, host string, requestPath string, secureOrigin bool, now time.Time) (entry *jarEntry, ok bool) {
+	if c.Validate() != nil {
+		return nil, false
+	}
+
+	// Only secure origins may set or overwrite Secure cookies.
+	if c.Secure && !secureOrigin {
+		return nil, false
+	}
+
+	entry = &jarEntry{Created: now}
+
+	if c.Domain == "" {
+		entry.HostOnly = true
+		entry.Host = host
+	} else {
+		c.Domain = strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+
+		// The domain must cover the host. Single label domains like "com" are refused since there is no public suffix list.
+		if !domainMatch(host, c.Domain) || !strings.Contains(c.Domain, ".") {
+			return nil, false
+		}
+		if net.ParseIP(host) != nil {
+			entry.HostOnly = true
+		}
+		entry.Host = c.Domain
+	}
+
+	if c.Path == "" || !strings.HasPrefix(c.Path, "/") {
+		c.Path = defaultPath(requestPath)
+	}
+
+	entry.Cookie = c
+	entry.Expiry = expiryOf(c, now)
+
+	return entry, true
+}
+
+// Stores the entry or deletes the stored one when the entry is already expired. The creation time of a replaced cookie is kept.
+func (j *Jar) store(entry *jarEntry, now time.Time) {
+	key := jarKey{domain: entry.Host, path: entry.Cookie.Path, name: entry.Cookie.Name}
+
+	if entry.expired(now) {
+		delete(j.entries, key)
+		return
+	}
+
+	if j.entries == nil {
+		j.entries = make(map[jarKey]*jarEntry)
+	}
+
+	if existing, exists := j.entries[key]; exists {
+		entry.Created = existing.Created
+	}
+
+	j.entries[key] = entry
+}
+
+func (j *Jar) clock() time.Time {
+	if j.now == nil {
+		return time.Now()
+	}
+
+	return j.now()
+}
+
+func (e *jarEntry) expired(now time.Time) bool {
+	return !e.Expiry.IsZero() && !e.Expiry.After(now)
+}
+
+// Max-Age takes precedence over Expires. A cookie with neither lives until the end of the session and has a zero expiry.
+func expiryOf(c Cookie, now time.Time) time.Time {
+	switch {
+	case c.MaxAge < 0:
+		return time.Unix(0, 0)
+	case c.MaxAge > 0:
+		return now.Add(time.Duration(c.MaxAge) * time.Second)
+	}
+
+	return c.Expires
+}
+
+func canonicalHost(u *url.URL) string {
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+func isSecureScheme(scheme string) bool {
+	return strings.EqualFold(scheme, "https") || strings.EqualFold(scheme, "wss")
+}
+
+// The host matches the domain when they are equal or when the host ends with "." followed by the domain and is not an IP address.
+func domainMatch(host string, domain string) bool {
+	if host == domain {
+		return true
+	}
+
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// The request path matches when it equals the cookie path or is below it.
+func pathMatch(requestPath string, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// The default path is the directory of the request path. Ref - https://www.rfc-editor.org/rfc/rfc6265#section-5.1.4
+func defaultPath(requestPath string) string {
+	if !strings.HasPrefix(requestPath, "/") {
+		return "/"
+	}
+
+	index := strings.LastIndex(requestPath, "/")
+	if index == 0 {
+		return "/"
+	}
+
+	return requestPath[:index]
+}
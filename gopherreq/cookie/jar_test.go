@@ -0,0 +1,64 @@
+package cookie
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A host-only __Host- cookie must survive a save and a load, and must only be sent back to its host.
+func TestJarHostPrefixRoundTrip(t *testing.T) {
+	jar := NewJar()
+	origin, _ := url.Parse("https://app.example.com/account")
+
+	jar.SetCookies(origin, []Cookie{{Name: "__Host-sid", Value: "abc", Path: "/", Secure: true, MaxAge: 3600}})
+
+	err := jar.SetForHost("app.example.com", Cookie{Name: "__Host-csrf", Value: "xyz", Secure: true, MaxAge: 3600})
+	if err != nil {
+		t.Fatalf("SetForHost: %v", err)
+	}
+
+	if err := jar.Set(Cookie{Name: "__Host-bad", Value: "1", Secure: true}); err == nil {
+		t.Errorf("Set accepted a cookie without a domain")
+	}
+
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	if err := jar.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := &Jar{}
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if got := len(loaded.Cookies(origin)); got != 2 {
+		t.Errorf("Cookies(%s) returned %d cookies after reload, want 2", origin, got)
+	}
+
+	other, _ := url.Parse("https://other.example.com/")
+	if got := len(loaded.Cookies(other)); got != 0 {
+		t.Errorf("Cookies(%s) returned %d host-only cookies of another host", other, got)
+	}
+}
+
+func TestJarLoadSkipsNullEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	expiry := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	data := `[null, {"cookie": {"Name": "a", "Value": "1", "Path": "/", "Domain": "example.com"}, "host": "example.com", "expiry": "` + expiry + `"}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	jar := &Jar{}
+	if err := jar.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if got := len(jar.All()); got != 1 {
+		t.Errorf("All() returned %d cookies, want 1", got)
+	}
+}
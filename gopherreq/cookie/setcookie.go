@@ -0,0 +1,301 @@
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ParseSetCookie parses the value of a Set-Cookie header received from a server.
+
+It follows the parsing algorithm of RFC 6265 section 5.2. Attribute names are case insensitive and the attributes it does not
+know are kept in Unparsed. A non positive Max-Age is stored as -1 which means the cookie must be deleted now.
+Ref - https://www.rfc-editor.org/rfc/rfc6265#section-5.2
+*/
+func ParseSetCookie(raw string) (c Cookie, err error) {
+	nameValuePair, attributes, _ := strings.Cut(raw, ";")
+
+	name, value, found := strings.Cut(nameValuePair, "=")
+	if !found {
+		err = ErrInvalidCookieFormat
+		return
+	}
+
+	name = trimWhitespace(name)
+	value = trimWhitespace(value)
+
+	if !isValidName(name) {
+		err = fmt.Errorf("%w: %s", ErrInvalidName, name)
+		return
+	}
+
+	if !isValidValue(value) {
+		err = fmt.Errorf("%w: %s", ErrInvalidValue, value)
+		return
+	}
+
+	// The quotes are not part of the value.
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	c = Cookie{
+		Name:  name,
+		Value: value,
+		Raw:   raw,
+	}
+
+	if attributes == "" {
+		return
+	}
+
+	for _, attribute := range strings.Split(attributes, ";") {
+		attribute = trimWhitespace(attribute)
+		if attribute == "" {
+			continue
+		}
+
+		attrName, attrValue, _ := strings.Cut(attribute, "=")
+		attrName = trimWhitespace(attrName)
+		attrValue = trimWhitespace(attrValue)
+
+		err = c.parseAttribute(attribute, attrName, attrValue)
+		if err != nil {
+			return Cookie{}, err
+		}
+	}
+
+	return
+}
+
+func (c *Cookie) parseAttribute(raw string, name string, value string) error {
+	switch strings.ToLower(name) {
+	case "expires":
+		expires, err := ParseCookieDate(value)
+		if err != nil {
+			return err
+		}
+		c.Expires = expires
+
+	case "max-age":
+		maxAge, err := parseMaxAge(value)
+		if err != nil {
+			return err
+		}
+		c.MaxAge = maxAge
+
+	case "domain":
+		// An empty domain is ignored and the cookie becomes host only.
+		domain := strings.ToLower(strings.TrimPrefix(value, "."))
+		if domain == "" {
+			return nil
+		}
+		if !isValidDomain(domain) {
+			return fmt.Errorf("%w: %s", ErrInvalidDomain, value)
+		}
+		c.Domain = domain
+
+	case "path":
+		// A path not starting with a slash is ignored so the default path of the request applies.
+		if !strings.HasPrefix(value, "/") {
+			return nil
+		}
+		if !isValidPath(value) {
+			return fmt.Errorf("%w: %s", ErrInvalidPath, value)
+		}
+		c.Path = value
+
+	case "secure":
+		c.Secure = true
+
+	case "httponly":
+		c.HttpOnly = true
+
+	case "partitioned":
+		c.Partitioned = true
+
+	case "samesite":
+		switch strings.ToLower(value) {
+		case "lax":
+			c.SameSite = SameSiteLaxMode
+		case "strict":
+			c.SameSite = SameSiteStrictMode
+		case "none":
+			c.SameSite = SameSiteNoneMode
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidSameSite, value)
+		}
+
+	default:
+		c.Unparsed = append(c.Unparsed, raw)
+	}
+
+	return nil
+}
+
+// Max-Age is made of digits with an optional leading minus. Zero and negative values expire the cookie right away.
+func parseMaxAge(value string) (maxAge int, err error) {
+	digits := strings.TrimPrefix(value, "-")
+	if digits == "" || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidMaxAge, value)
+	}
+
+	maxAge, err = strconv.Atoi(value)
+	if err != nil {
+		// The value only has digits so it is too large. It is capped instead of rejected.
+		if strings.HasPrefix(value, "-") {
+			return -1, nil
+		}
+		return int(^uint(0) >> 1), nil
+	}
+
+	if maxAge <= 0 {
+		maxAge = -1
+	}
+
+	return maxAge, nil
+}
+
+/*
+ParseCookieDate parses the date of the Expires attribute using the algorithm of RFC 6265 section 5.1.1.
+
+It accepts every format used in the wild, like RFC 1123 (Sun, 06 Nov 1994 08:49:37 GMT), RFC 850 (Sunday, 06-Nov-94 08:49:37 GMT),
+ANSI C asctime (Sun Nov  6 08:49:37 1994) and the Netscape format (Sun, 06-Nov-1994 08:49:37 GMT). The date is always in UTC.
+Ref - https://www.rfc-editor.org/rfc/rfc6265#section-5.1.1
+*/
+func ParseCookieDate(value string) (date time.Time, err error) {
+	var (
+		foundTime, foundDay, foundMonth, foundYear bool
+		hour, minute, second, day, year            int
+		month                                      time.Month
+	)
+
+	for _, token := range strings.FieldsFunc(value, isDateDelimiter) {
+		if !foundTime {
+			if h, m, s, ok := parseDateTime(token); ok {
+				hour, minute, second, foundTime = h, m, s, true
+				continue
+			}
+		}
+
+		if !foundDay {
+			if d, ok := parseDateDigits(token, 1, 2); ok {
+				day, foundDay = d, true
+				continue
+			}
+		}
+
+		if !foundMonth {
+			if m, ok := parseDateMonth(token); ok {
+				month, foundMonth = m, true
+				continue
+			}
+		}
+
+		if !foundYear {
+			if y, ok := parseDateDigits(token, 2, 4); ok {
+				year, foundYear = y, true
+				continue
+			}
+		}
+	}
+
+	if !foundTime || !foundDay || !foundMonth || !foundYear {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidExpires, value)
+	}
+
+	// Two digit years from 70 belong to the 1900s and the ones below to the 2000s.
+	if year >= 70 && year <= 99 {
+		year += 1900
+	} else if year >= 0 && year <= 69 {
+		year += 2000
+	}
+
+	if day < 1 || day > 31 || year < 1601 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidExpires, value)
+	}
+
+	date = time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+
+	// Dates like the 31st of February roll over into the next month and are invalid.
+	if date.Day() != day {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidExpires, value)
+	}
+
+	return date, nil
+}
+
+// delimiter = %x09 / %x20-2F / %x3B-40 / %x5B-60 / %x7B-7E
+func isDateDelimiter(r rune) bool {
+	return r == '\t' ||
+		(r >= 0x20 && r <= 0x2f) ||
+		(r >= 0x3b && r <= 0x40) ||
+		(r >= 0x5b && r <= 0x60) ||
+		(r >= 0x7b && r <= 0x7e)
+}
+
+// Parses a token made of minDigits to maxDigits digits optionally followed by non digit characters.
+func parseDateDigits(token string, minDigits int, maxDigits int) (value int, ok bool) {
+	digits := 0
+	for digits < len(token) && token[digits] >= '0' && token[digits] <= '9' {
+		digits++
+	}
+
+	if digits < minDigits || digits > maxDigits {
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(token[:digits])
+
+	return value, err == nil
+}
+
+// time = hms-time ( non-digit *OCTET ) where hms-time = time-field ":" time-field ":" time-field
+func parseDateTime(token string) (hour int, minute int, second int, ok bool) {
+	fields := strings.SplitN(token, ":", 3)
+	if len(fields) != 3 {
+		return
+	}
+
+	values := [3]int{}
+	for index, field := range fields {
+		// Only the last field may be followed by other characters.
+		if index < 2 {
+			if len(field) < 1 || len(field) > 2 || strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+				return
+			}
+		}
+
+		value, valid := parseDateDigits(field, 1, 2)
+		if !valid {
+			return
+		}
+		values[index] = value
+	}
+
+	return values[0], values[1], values[2], true
+}
+
+var cookieMonths = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+// The month is recognized by its first three letters.
+func parseDateMonth(token string) (month time.Month, ok bool) {
+	if len(token) < 3 {
+		return
+	}
+
+	month, ok = cookieMonths[strings.ToLower(token[:3])]
+
+	return
+}
+
+// Trims the spaces and tabs around the value.
+func trimWhitespace(value string) string {
+	return strings.Trim(value, " \t")
+}
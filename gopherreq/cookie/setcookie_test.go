@@ -0,0 +1,161 @@
+package cookie
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCookieDate(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		date  time.Time
+		valid bool
+	}{
+		{"Sun, 06 Nov 1994 08:49:37 GMT", want, true},     // RFC 1123
+		{"Sunday, 06-Nov-94 08:49:37 GMT", want, true},    // RFC 850
+		{"Sun Nov  6 08:49:37 1994", want, true},          // asctime
+		{"Sun, 06-Nov-1994 08:49:37 GMT", want, true},     // Netscape
+		{"06 NOVEMBER 1994 8:49:37", want, true},          // Full month in any case, single digit hour
+		{"1994 Nov 06 08:49:37", want, true},              // Tokens in any order
+		{"Sun, 06 Nov 1994 08:49:37 +0100", want, true},   // The zone is ignored, the date is UTC
+		{"Sun, 06 Nov 1994 08:49:37zone GMT", want, true}, // Characters after the time are ignored
+		{"Thu, 01-Jan-70 00:00:00 GMT", time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Thu, 31-Dec-69 23:59:59 GMT", time.Date(2069, time.December, 31, 23, 59, 59, 0, time.UTC), true},
+		{"Sat, 01-Jan-00 00:00:00 GMT", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Sun, 06 Nov 1994 GMT", time.Time{}, false},          // No time
+		{"Sun, Nov 1994 08:49:37 GMT", time.Time{}, false},    // No day
+		{"Sun, 06 1994 08:49:37 GMT", time.Time{}, false},     // No month
+		{"Sun, 06 Nov 08:49:37 GMT", time.Time{}, false},      // No year
+		{"Mon, 31 Feb 2025 08:49:37 GMT", time.Time{}, false}, // Rolls over into March
+		{"Sun, 32 Nov 1994 08:49:37 GMT", time.Time{}, false},
+		{"Sun, 06 Nov 1994 24:00:00 GMT", time.Time{}, false},
+		{"Sun, 06 Nov 1994 08:60:00 GMT", time.Time{}, false},
+		{"Sun, 06 Nov 1600 08:49:37 GMT", time.Time{}, false}, // Before 1601
+		{"Sun, 06 Nov 994 08:49:37 GMT", time.Time{}, false},  // Three digit year
+		{"Sun, 06 Nov 1994 108:49:37 GMT", time.Time{}, false},
+		{"", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		date, err := ParseCookieDate(test.value)
+
+		if !test.valid {
+			if !errors.Is(err, ErrInvalidExpires) {
+				t.Errorf("ParseCookieDate(%q) = %v, %v, want ErrInvalidExpires", test.value, date, err)
+			}
+			continue
+		}
+
+		if err != nil || !date.Equal(test.date) || date.Location() != time.UTC {
+			t.Errorf("ParseCookieDate(%q) = %v, %v, want %v", test.value, date, err, test.date)
+		}
+	}
+}
+
+func TestParseSetCookie(t *testing.T) {
+	expires := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+
+	tests := []struct {
+		raw    string
+		cookie Cookie
+		err    error
+	}{
+		{"id=abc", Cookie{Name: "id", Value: "abc"}, nil},
+		{" id = abc ;", Cookie{Name: "id", Value: "abc"}, nil},
+		{"id=", Cookie{Name: "id"}, nil},
+		{`id="abc"`, Cookie{Name: "id", Value: "abc"}, nil},
+		{"id=a b,c", Cookie{Name: "id", Value: "a b,c"}, nil},
+		{
+			"id=abc; Path=/app; Domain=.Example.COM; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict; Partitioned",
+			Cookie{Name: "id", Value: "abc", Path: "/app", Domain: "example.com", Expires: expires, MaxAge: 3600, Secure: true, HttpOnly: true, SameSite: SameSiteStrictMode, Partitioned: true},
+			nil,
+		},
+		{"id=abc; path=/; SECURE; httponly; samesite=lax", Cookie{Name: "id", Value: "abc", Path: "/", Secure: true, HttpOnly: true, SameSite: SameSiteLaxMode}, nil},
+		{"id=abc; SameSite=None; Secure", Cookie{Name: "id", Value: "abc", SameSite: SameSiteNoneMode, Secure: true}, nil},
+		{"id=abc; Expires=Wednesday, 21-Oct-15 07:28:00 GMT", Cookie{Name: "id", Value: "abc", Expires: expires}, nil},
+		{"id=abc; Expires=Wed Oct 21 07:28:00 2015", Cookie{Name: "id", Value: "abc", Expires: expires}, nil},
+		{"id=abc; Max-Age=0", Cookie{Name: "id", Value: "abc", MaxAge: -1}, nil},
+		{"id=abc; Max-Age=-5", Cookie{Name: "id", Value: "abc", MaxAge: -1}, nil},
+		{"id=abc; Max-Age=99999999999999999999", Cookie{Name: "id", Value: "abc", MaxAge: int(^uint(0) >> 1)}, nil},
+		{"id=abc; Max-Age=-99999999999999999999", Cookie{Name: "id", Value: "abc", MaxAge: -1}, nil},
+		{"id=abc; Max-Age=60; Max-Age=120", Cookie{Name: "id", Value: "abc", MaxAge: 120}, nil},
+		{"id=abc; Path=relative", Cookie{Name: "id", Value: "abc"}, nil},
+		{"id=abc; Domain=", Cookie{Name: "id", Value: "abc"}, nil},
+		{"id=abc; Priority=High; Version=1; Comment", Cookie{Name: "id", Value: "abc", Unparsed: []string{"Priority=High", "Version=1", "Comment"}}, nil},
+		{"id=abc;;  ; Secure", Cookie{Name: "id", Value: "abc", Secure: true}, nil},
+
+		{"", Cookie{}, ErrInvalidCookieFormat},
+		{"abc", Cookie{}, ErrInvalidCookieFormat},
+		{"=abc", Cookie{}, ErrInvalidName},
+		{"i d=abc", Cookie{}, ErrInvalidName},
+		{`id=a\b`, Cookie{}, ErrInvalidValue},
+		{`id=a"b`, Cookie{}, ErrInvalidValue},
+		{"id=a\x01b", Cookie{}, ErrInvalidValue},
+		{"id=caf\u00e9", Cookie{}, ErrInvalidValue},
+		{"id=abc; Expires=soon", Cookie{}, ErrInvalidExpires},
+		{"id=abc; Expires=", Cookie{}, ErrInvalidExpires},
+		{"id=abc; Max-Age=1h", Cookie{}, ErrInvalidMaxAge},
+		{"id=abc; Max-Age=+60", Cookie{}, ErrInvalidMaxAge},
+		{"id=abc; Max-Age=", Cookie{}, ErrInvalidMaxAge},
+		{"id=abc; Max-Age=-", Cookie{}, ErrInvalidMaxAge},
+		{"id=abc; SameSite=Sometimes", Cookie{}, ErrInvalidSameSite},
+		{"id=abc; Domain=exa mple.com", Cookie{}, ErrInvalidDomain},
+		{"id=abc; Path=/a\x00b", Cookie{}, ErrInvalidPath},
+	}
+
+	for _, test := range tests {
+		c, err := ParseSetCookie(test.raw)
+
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("ParseSetCookie(%q) error = %v, want %v", test.raw, err, test.err)
+			}
+			continue
+		}
+
+		test.cookie.Raw = test.raw
+		if err != nil || !reflect.DeepEqual(c, test.cookie) {
+			t.Errorf("ParseSetCookie(%q) = %+v, %v, want %+v", test.raw, c, err, test.cookie)
+		}
+	}
+}
+
+// Both attributes are kept by the parser and the jar gives Max-Age the precedence.
+func TestSetCookieMaxAgePrecedence(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC1123)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC1123)
+
+	tests := []struct {
+		raw    string
+		stored bool
+	}{
+		{"id=abc; Expires=" + future, true},
+		{"id=abc; Expires=" + past, false},
+		{"id=abc; Max-Age=3600; Expires=" + past, true},
+		{"id=abc; Expires=" + past + "; Max-Age=3600", true},
+		{"id=abc; Max-Age=0; Expires=" + future, false},
+		{"id=abc; Max-Age=-1; Expires=" + future, false},
+	}
+
+	origin, _ := url.Parse("https://example.com/")
+
+	for _, test := range tests {
+		c, err := ParseSetCookie(test.raw)
+		if err != nil {
+			t.Fatalf("ParseSetCookie(%q): %v", test.raw, err)
+		}
+
+		jar := NewJar()
+		jar.SetCookies(origin, []Cookie{c})
+
+		if stored := len(jar.Cookies(origin)) == 1; stored != test.stored {
+			t.Errorf("%q stored = %v, want %v", test.raw, stored, test.stored)
+		}
+	}
+}
@@ -1,138 +1,516 @@
 package gopherreq
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"log/slog"
 	"net"
-	"os"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const HEADER_LIMIT_BYTES = uint32(8192)
 
-var supportedHttpMethods = []common.HttpMethod{common.Get, common.Post, common.Put, common.Delete}
+// Number of header fields accepted in a request when the config does not set it.
+const DEFAULT_MAX_HEADER_COUNT = 100
+
+// Size of a single header field line accepted when the config does not set it.
+const DEFAULT_MAX_HEADER_FIELD_BYTES = 4096
+
+// Time allowed to send the error response to a client which sent a broken request.
+const ERROR_RESPONSE_TIMEOUT = 2 * time.Second
+
+// Time spent discarding the remaining input before closing a connection which sent a broken request.
+const LINGER_TIMEOUT = 500 * time.Millisecond
+
+// Time allowed to read the headers when the config does not set it.
+const DEFAULT_READ_HEADER_TIMEOUT = 10 * time.Second
+
+// Time to wait for a request on an idle connection when the config does not set it.
+const DEFAULT_IDLE_TIMEOUT = 60 * time.Second
+
+// How often the shutdown checks if the active connections have finished.
+const SHUTDOWN_POLL_INTERVAL = 50 * time.Millisecond
+
+// The version sent in every response line. It is the highest version the server speaks, whatever the version of the request.
+// Ref - https://www.rfc-editor.org/rfc/rfc9110#section-6.2
+const SERVER_HTTP_VERSION = "HTTP/1.1"
+
+// The major version of the protocol served. Requests with any minor version of it are accepted.
+const SUPPORTED_HTTP_MAJOR = 1
 
 type Config struct {
-	Domain  string
-	Timeout int
+	Domain             string         // The address to listen on. It is used along with Addresses.
+	Addresses          []string       // Additional addresses to listen on. See parseListenAddress for the accepted formats.
+	Listeners          []net.Listener // Listeners opened by the caller. The server takes ownership and closes them on shutdown.
+	Handler            Handler        // The handler which serves every request. Defaults to NotFoundHandler.
+	MaxRequestsPerConn int            // The number of requests served on a connection before it is closed. Zero means no limit.
+
+	ReadHeaderTimeout time.Duration // Time allowed to read the request line and the headers once the request started. Defaults to DEFAULT_READ_HEADER_TIMEOUT. A 408 is sent when it passes.
+	ReadBodyTimeout   time.Duration // Time allowed to read the body after the headers. Zero means no limit, though the part left unread by the handler must still arrive within ReadHeaderTimeout. A 408 is sent when it passes before the response started.
+	WriteTimeout      time.Duration // Time allowed for each write of the response to the client. Zero means no limit.
+	IdleTimeout       time.Duration // Time to wait for a request on a new or persistent connection. Defaults to DEFAULT_IDLE_TIMEOUT.
+	HandlerTimeout    time.Duration // Time allowed for the handler to serve the request. Zero means no limit. A 503 is sent when it passes before the response started.
+
+	MaxHeaderBytes      int  // Size of the request line and the headers together. Defaults to HEADER_LIMIT_BYTES. A 431 is sent when it is exceeded.
+	MaxHeaderFieldBytes int  // Size of a single header field line. Defaults to DEFAULT_MAX_HEADER_FIELD_BYTES. A 431 is sent when it is exceeded.
+	MaxHeaderCount      int  // Number of header fields in a request. Defaults to DEFAULT_MAX_HEADER_COUNT. A 431 is sent when it is exceeded.
+	LenientHeaders      bool // Accepts malformed header lines instead of answering 400. Only meant to debug legacy clients since it allows request smuggling.
+
+	EnableTrace bool // Answers TRACE requests by echoing the request. It is off by default since the echo can leak headers added by proxies. A 405 is sent while disabled.
+
+	Middlewares []Middleware     // Wrap the handler for every request. The first one is the outermost.
+	ErrorLog    *slog.Logger     // Receives the errors of the server which can not be reported to a client. Defaults to slog.Default().
+	AccessLog   *AccessLogConfig // Enables the access log with a line for every request served.
 }
 
 type HttpServer struct {
-	listener net.Listener
-	timeout  int
+	listeners          []net.Listener
+	readHeaderTimeout  time.Duration
+	readBodyTimeout    time.Duration
+	writeTimeout       time.Duration
+	idleTimeout        time.Duration
+	handlerTimeout     time.Duration
+	maxRequestsPerConn int
+	headerOptions      headerOptions
+	enableTrace        bool
+	handler            Handler
+	errorLog           *slog.Logger
+	accessLog          *accessLog
+
+	mu             sync.Mutex
+	conns          map[net.Conn]connState // The open connections with their state.
+	inShutdown     atomic.Bool
+	baseCtx        context.Context // The parent of the context of every request.
+	cancelRequests context.CancelFunc
 }
 
-func NewServer(cfg Config) (server HttpServer, err error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:8811")
+// Creates the server and opens its listeners. If neither an address nor a listener is configured it listens on DEFAULT_ADDRESS.
+func NewServer(cfg Config) (server *HttpServer, err error) {
+	addresses := cfg.Addresses
+	if cfg.Domain != "" {
+		addresses = append([]string{cfg.Domain}, addresses...)
+	}
+
+	if len(addresses) == 0 && len(cfg.Listeners) == 0 {
+		addresses = []string{DEFAULT_ADDRESS}
+	}
+
+	listeners, err := listenAll(addresses)
 	if err != nil {
-		fmt.Printf("Error while listening to the socket: %v\n", err)
 		return
 	}
 
-	server.listener = listener
-	server.timeout = cfg.Timeout
+	server = &HttpServer{}
+	server.listeners = append(listeners, cfg.Listeners...)
+	server.conns = make(map[net.Conn]connState)
+	server.baseCtx, server.cancelRequests = context.WithCancel(context.Background())
+	server.readHeaderTimeout = cfg.ReadHeaderTimeout
+	server.readBodyTimeout = cfg.ReadBodyTimeout
+	server.writeTimeout = cfg.WriteTimeout
+	server.idleTimeout = cfg.IdleTimeout
+	server.handlerTimeout = cfg.HandlerTimeout
+	server.maxRequestsPerConn = cfg.MaxRequestsPerConn
+	server.headerOptions = headerOptions{
+		lenient:       cfg.LenientHeaders,
+		maxBytes:      cfg.MaxHeaderBytes,
+		maxCount:      cfg.MaxHeaderCount,
+		maxFieldBytes: cfg.MaxHeaderFieldBytes,
+	}
+	server.enableTrace = cfg.EnableTrace
+	server.handler = cfg.Handler
+
+	if server.readHeaderTimeout == 0 {
+		server.readHeaderTimeout = DEFAULT_READ_HEADER_TIMEOUT
+	}
+
+	if server.idleTimeout == 0 {
+		server.idleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+
+	if server.headerOptions.maxBytes <= 0 {
+		server.headerOptions.maxBytes = int(HEADER_LIMIT_BYTES)
+	}
+
+	if server.headerOptions.maxCount <= 0 {
+		server.headerOptions.maxCount = DEFAULT_MAX_HEADER_COUNT
+	}
+
+	if server.headerOptions.maxFieldBytes <= 0 {
+		server.headerOptions.maxFieldBytes = DEFAULT_MAX_HEADER_FIELD_BYTES
+	}
+
+	if server.handler == nil {
+		server.handler = NotFoundHandler
+	}
+
+	server.handler = Chain(server.handler, cfg.Middlewares...)
+
+	server.errorLog = cfg.ErrorLog
+	if server.errorLog == nil {
+		server.errorLog = slog.Default()
+	}
+
+	if cfg.AccessLog != nil {
+		server.accessLog = newAccessLog(*cfg.AccessLog)
+	}
 
 	return
 }
 
-func (s *HttpServer) Listen() {
+/*
+Shutdown stops the server gracefully.
+
+It stops accepting new connections, closes the idle ones and waits for the active ones to finish their current request.
+Connections kept alive are closed after their response. If the context ends before every connection is closed, the remaining
+ones are closed forcefully, the context of their requests is cancelled and the context error is returned.
+*/
+func (s *HttpServer) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	err := s.closeListeners()
+
+	ticker := time.NewTicker(SHUTDOWN_POLL_INTERVAL)
+	defer ticker.Stop()
 
 	for {
-		conn, err := s.listener.Accept()
-		if err != nil {
-			fmt.Printf("Error while accepting the connection: %v\n", err)
-			continue
+		if s.closeIdleConns() {
+			return err
 		}
 
-		conn.SetDeadline(time.Now().Add(time.Duration(s.timeout) * time.Millisecond))
-
-		go s.handleConnection(conn)
+		select {
+		case <-ctx.Done():
+			s.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
 }
 
-func (s *HttpServer) ShutDown() {
-	s.listener.Close()
+func (s *HttpServer) shuttingDown() bool {
+	return s.inShutdown.Load()
 }
 
+// Serves the requests on the connection one after another until the client or the server decides to close it.
 func (s *HttpServer) handleConnection(conn net.Conn) {
 
-	request, err := s.readHeader(conn)
-	if err != nil {
-		fmt.Printf("error while reading the header %v:", err)
-		os.Exit(1)
+	defer s.forgetConn(conn)
+	defer conn.Close()
+
+	// The reader is kept for the lifetime of the connection so pipelined requests read along with the previous one are not lost.
+	reader := bufio.NewReader(conn)
+
+	// Every write extends the write deadline so a slow handler is never cut off by it, only a client which stops reading.
+	output := deadlineWriter{conn: conn, timeout: s.writeTimeout}
+
+	for servedRequests := 0; ; servedRequests++ {
+
+		// Wait for the first byte of the next request. The client is allowed to close an idle connection at any time.
+		conn.SetReadDeadline(deadlineAfter(s.idleTimeout))
+		if _, err := reader.Peek(1); err != nil {
+			return
+		}
+
+		// The shutdown might have closed the connection while it was idle.
+		if !s.setConnState(conn, stateActive) {
+			return
+		}
+
+		request, err := s.readHeader(conn, reader)
+		if err == nil {
+			err = parseRequestCookie(&request)
+		}
+		if err == nil {
+			err = request.readBody(reader, s.headerOptions)
+			conn.SetReadDeadline(deadlineAfter(s.readBodyTimeout))
+		}
+
+		// A broken request leaves the connection in an unknown state so only the offending connection is closed after answering it.
+		if err != nil {
+			if httpErr, ok := httperr.AsHTTPError(err); ok {
+				writeErrorResponse(httpErr, conn)
+				lingerClose(conn)
+			}
+			return
+		}
+
+		start := time.Now()
+
+		if remoteAddr := conn.RemoteAddr(); remoteAddr != nil {
+			request.RemoteAddr = remoteAddr.String()
+		}
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			request.TLS = &state
+		}
+		request.logger = s.errorLog
+
+		keepAlive := s.shouldKeepAlive(request, servedRequests+1)
+
+		var cancelRequest context.CancelFunc
+		if s.handlerTimeout > 0 {
+			request.ctx, cancelRequest = context.WithTimeout(s.baseCtx, s.handlerTimeout)
+		} else {
+			request.ctx, cancelRequest = context.WithCancel(s.baseCtx)
+		}
+
+		writer := newResponseWriter(output, &request)
+
+		if !keepAlive {
+			writer.Header().Set("Connection", "close")
+		} else if !request.ProtoAtLeast(1, 1) {
+			// HTTP/1.0 connections are closed by default so the client must be told that it stays open.
+			writer.Header().Set("Connection", "keep-alive")
+		}
+
+		finished := s.runHandler(writer, &request)
+		cancelRequest()
+
+		// The handler keeps running in the background after its deadline so the connection can not be used anymore.
+		if !finished {
+			s.logAccess(&request, start, writer)
+			return
+		}
+
+		if bodyTimedOut(request.Body) {
+			writer.abort(httperr.ErrRequestBodyTimeout)
+			s.logAccess(&request, start, writer)
+			return
+		}
+
+		err = writer.finish()
+		s.logAccess(&request, start, writer)
+		if err != nil || !keepAlive || writer.shouldClose() || s.shuttingDown() {
+			return
+		}
+
+		// The next request starts after the body so whatever the handler did not read is thrown away. Without a body timeout the
+		// discard is still bounded, otherwise a client trickling the body would hold the connection forever.
+		if s.readBodyTimeout <= 0 {
+			conn.SetReadDeadline(deadlineAfter(s.discardTimeout()))
+		}
+		if !discardBody(request.Body) {
+			return
+		}
+
+		if !s.setConnState(conn, stateIdle) {
+			return
+		}
 	}
+}
 
-	err = parseRequestCookie(&request)
-	if err != nil {
-		fmt.Printf("error while reading the cookies %v:", err)
-		os.Exit(1)
+/*
+Runs the handler for the request.
+
+Without a handler timeout it runs on the connection goroutine. Otherwise it runs in its own goroutine and if the deadline passes
+first, the response is replaced with a 503 when nothing was sent yet and false is returned.
+*/
+func (s *HttpServer) runHandler(writer *responseWriter, request *HttpRequest) (finished bool) {
+	if s.handlerTimeout <= 0 {
+		return s.callHandler(writer, request)
 	}
 
-	err = request.readBody(conn)
-	if err != nil {
-		fmt.Printf("error while reading the body %v:", err)
-		os.Exit(1)
+	done := make(chan bool, 1)
+	go func() {
+		done <- s.callHandler(writer, request)
+	}()
+
+	select {
+	case finished = <-done:
+		return
+	case <-request.Context().Done():
 	}
 
-	response := generateHttpWireResponse(request)
+	// The handler may have returned right when the deadline passed.
+	select {
+	case finished = <-done:
+		return
+	default:
+	}
 
-	err = writeResponse(response, conn)
+	writer.abort(httperr.ErrHandlerTimeout)
 
-	if err != nil {
-		// Do nothing for now.
-		// TODO - Add handling for the situation.
+	return false
+}
+
+/*
+Calls the handler and recovers from its panics so a bad handler can not crash the server.
+
+A panic reaching this point aborts the response and the connection is closed since the handler might have left it in any state.
+Use the Recovery middleware to answer with a 500 and keep the connection open.
+*/
+func (s *HttpServer) callHandler(writer *responseWriter, request *HttpRequest) (finished bool) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		if recovered != httperr.ErrAbortHandler {
+			request.Logger().Error("panic serving request", "panic", recovered, "stack", string(debug.Stack()))
+		}
+
+		writer.abort(httperr.ErrHandlerPanic)
+		finished = false
+	}()
+
+	s.handlerFor(request).ServeHttp(writer, request)
+
+	return true
+}
+
+// Returns the handler for the request. The requests about the server itself rather than a resource are answered by the server.
+func (s *HttpServer) handlerFor(request *HttpRequest) Handler {
+	switch {
+	case request.Method == common.Options && request.URI.Path == "*":
+		return HandlerFunc(serveServerOptions)
+	case request.Method == common.Trace:
+		if !s.enableTrace {
+			return HandlerFunc(serveTraceDisabled)
+		}
+		return HandlerFunc(serveTrace)
 	}
 
-	defer conn.Close()
+	return s.handler
+}
 
+// Writes the access log line of the request if the access log is enabled.
+func (s *HttpServer) logAccess(request *HttpRequest, start time.Time, writer *responseWriter) {
+	if s.accessLog == nil {
+		return
+	}
+
+	status, written := writer.result()
+	s.accessLog.write(newAccessLogEntry(request, start, status, written))
 }
 
-func generateHttpWireResponse(request HttpRequest) (response HttpWireResponse) {
+// Returns the deadline for a timeout starting now. A zero timeout gives the zero time which means no deadline.
+// Time given to the client to send the rest of a body the handler did not read when no body timeout is set.
+func (s *HttpServer) discardTimeout() time.Duration {
+	if s.readHeaderTimeout > 0 {
+		return s.readHeaderTimeout
+	}
 
-	respCode := common.StatusCode(OK)
+	return s.idleTimeout
+}
+
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(timeout)
+}
 
-	if request.Method != common.Get {
-		respCode = common.StatusCode(NOT_IMPLEMENTED)
+// Decides if the connection can be reused after the request based on the protocol version and the Connection header.
+func (s *HttpServer) shouldKeepAlive(request HttpRequest, servedRequests int) bool {
+	if s.shuttingDown() {
+		return false
 	}
 
-	respLine := ResponseLine{
-		Code:    respCode,
-		Reason:  httpStatusPhraseReasons[respCode],
-		Version: request.Version,
+	if s.maxRequestsPerConn > 0 && servedRequests >= s.maxRequestsPerConn {
+		return false
 	}
 
-	headers := make(Headers)
+	if request.Headers.HasToken("Connection", "close") {
+		return false
+	}
 
-	response = HttpWireResponse{
-		ResponseLine: respLine,
-		Headers:      headers,
+	// HTTP/1.1 connections are persistent unless closed while HTTP/1.0 needs to ask for it.
+	if !request.ProtoAtLeast(1, 1) {
+		return request.Headers.HasToken("Connection", "keep-alive")
 	}
 
-	response.Headers.Set("Transfer-Encoding", "chunked")
+	return true
+}
+
+// Answers a request which could not be parsed. The connection is always closed afterwards.
+func writeErrorResponse(httpErr *httperr.HTTPError, conn net.Conn) (err error) {
+
+	conn.SetWriteDeadline(time.Now().Add(ERROR_RESPONSE_TIMEOUT))
+
+	return writeResponse(newErrorResponse(httpErr), conn)
+}
+
+// Builds the response sent for the error. It always asks the client to close the connection.
+func newErrorResponse(httpErr *httperr.HTTPError) (response HttpWireResponse) {
+
+	body := httpErr.Reason
+
+	response = HttpWireResponse{
+		ResponseLine: ResponseLine{
+			Code:    httpErr.Code,
+			Reason:  httpErr.Reason,
+			Version: SERVER_HTTP_VERSION,
+		},
+		Headers: make(Headers),
+		Body:    io.NopCloser(strings.NewReader(body)),
+	}
 
+	response.Headers.Set("Content-Type", "text/plain; charset=utf-8")
+	response.Headers.Set("Content-Length", HeaderValue(strconv.Itoa(len(body))))
+	response.Headers.Set("Connection", "close")
 	response.StandardizeHeaders()
 
 	return
-
 }
 
-func writeResponse(response HttpWireResponse, conn net.Conn) (err error) {
+/*
+Closes the writing side of the connection and discards what the client is still sending for a short while.
+
+Closing a socket with unread data makes the kernel reset the connection which can destroy the error response before the client reads it.
+*/
+func lingerClose(conn net.Conn) {
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(LINGER_TIMEOUT))
+	io.CopyN(io.Discard, conn, MAX_DISCARD_BODY_BYTES)
+}
 
-	transferEncoding := response.Headers.Get("Transfer-Encoding")
+// Writes a complete response including the body to the connection.
+func writeResponse(response HttpWireResponse, conn io.Writer) (err error) {
 
-	if strings.EqualFold(transferEncoding.String(), "chunked") {
+	if response.IsChunked() {
 		response.Headers.Remove("Content-Length")
 	}
 
 	err = writeHeaderResponse(response, conn)
+	if err != nil || response.Body == nil {
+		return
+	}
+
+	defer response.Body.Close()
+
+	if !bodyAllowedForStatus(response.ResponseLine.Code) {
+		return
+	}
+
+	if response.IsChunked() {
+		chunked := newChunkedWriter(conn)
+		_, err = io.Copy(chunked, response.Body)
+		if err != nil {
+			return
+		}
+		return chunked.Close()
+	}
 
-	// TODO - Add body writing for the response.
+	_, err = io.Copy(conn, response.Body)
 
 	return
 }
 
-func writeHeaderResponse(response HttpWireResponse, conn net.Conn) (err error) {
+func writeHeaderResponse(response HttpWireResponse, conn io.Writer) (err error) {
 
 	serializedResponse := strings.Builder{}
 
@@ -142,7 +520,7 @@ func writeHeaderResponse(response HttpWireResponse, conn net.Conn) (err error) {
 	// Write the headers to the output.
 	for key, values := range response.Headers {
 		for _, value := range values {
-			serializedResponse.WriteString(fmt.Sprintf("%s: %s\n", key, value))
+			serializedResponse.WriteString(fmt.Sprintf("%s: %s%s", key, value, common.CRLF))
 		}
 	}
 
@@ -0,0 +1,62 @@
+package gopherreq
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Without a body timeout the part of the body left unread by the handler must still arrive in time or the connection is closed.
+func TestDiscardBodyIsBounded(t *testing.T) {
+	server := startTestServer(t, Config{
+		ReadHeaderTimeout: 200 * time.Millisecond,
+		Handler: HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+			w.Write([]byte("ok"))
+		}),
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000\r\n\r\nabc")
+
+	reader := bufio.NewReader(conn)
+	if status := readResponseHead(t, reader); status != "HTTP/1.1 200 OK" {
+		t.Fatalf("got %q", status)
+	}
+
+	// The client keeps trickling the body, which must not extend the deadline of the discard.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := conn.Write([]byte("x")); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	// The bytes left unread make the close a reset, either way the server must end the connection before the client deadline.
+	_, err = reader.ReadByte()
+	if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+		t.Fatalf("read after the response returned %v, want the connection closed", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("connection closed after %v", elapsed)
+	}
+}
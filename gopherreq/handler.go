@@ -0,0 +1,39 @@
+package gopherreq
+
+import "gopherreq/gopherreq/common"
+
+// A Handler responds to a single HTTP request. It receives the parsed request and writes the response through the ResponseWriter.
+type Handler interface {
+	ServeHttp(w ResponseWriter, req *HttpRequest)
+}
+
+// HandlerFunc allows the use of ordinary functions as handlers.
+type HandlerFunc func(w ResponseWriter, req *HttpRequest)
+
+func (f HandlerFunc) ServeHttp(w ResponseWriter, req *HttpRequest) {
+	f(w, req)
+}
+
+// ResponseWriter is used by a handler to build the response for the request.
+type ResponseWriter interface {
+	// Returns the headers which will be sent with the response. Changing them after WriteHeader has no effect.
+	Header() Headers
+	// Sets the status code of the response. Only the first call is honoured.
+	WriteHeader(code common.StatusCode)
+	// Writes the data as part of the response body. It sets the status to 200 if WriteHeader was not called.
+	Write(data []byte) (int, error)
+	// Sends the headers and any buffered body to the client. After the first flush the body is streamed using chunked transfer coding unless Content-Length was set.
+	Flush() error
+}
+
+// Writes a plain text error response with the reason phrase of the code as the body.
+func Error(w ResponseWriter, code common.StatusCode) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(httpStatusPhraseReasons[code]))
+}
+
+// Handler used when nothing matches the request.
+var NotFoundHandler = HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+	Error(w, NOT_FOUND)
+})
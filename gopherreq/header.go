@@ -3,17 +3,32 @@ package gopherreq
 import (
 	"gopherreq/gopherreq/common"
 	"net/url"
+	"strings"
 )
 
 type HeaderValue string
 type Headers map[string][]HeaderValue
 
 type RequestLine struct {
-	URI     url.URL
-	Version string
-	Method  common.HttpMethod
+	URI        url.URL
+	Version    string
+	ProtoMajor int
+	ProtoMinor int
+	Method     common.HttpMethod
+	RawTarget  string            // The request target as received.
+	TargetForm RequestTargetForm // The form of the request target.
 }
 
+// The forms of the request target in the request line. Ref - https://www.rfc-editor.org/rfc/rfc9112#section-3.2
+type RequestTargetForm int
+
+const (
+	OriginForm    RequestTargetForm = iota // The path and query, like "/users?id=1". Used by most requests.
+	AbsoluteForm                           // The whole URI, like "http://example.com/users". Sent to proxies.
+	AuthorityForm                          // Only the host and port, like "example.com:443". Only for CONNECT.
+	AsteriskForm                           // "*" for the whole server instead of a resource. Only for OPTIONS.
+)
+
 func (h HeaderValue) String() string {
 	return string(h)
 }
@@ -61,3 +76,65 @@ func (h Headers) Remove(key string) {
 	canonicalKey := common.GetCanonicalName(key)
 	delete(h, canonicalKey)
 }
+
+// Reports if any of the comma separated values of the header contains the token. The comparison is case insensitive.
+func (h Headers) HasToken(key string, token string) bool {
+	for _, value := range h.GetAllValues(key) {
+		for _, part := range strings.Split(value.String(), ",") {
+			if strings.EqualFold(strings.Trim(part, " \t"), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+Reports if the string is a token, the grammar of the field names and the methods.
+
+	token = 1*tchar
+	tchar = "!" / "#" / "$" / "%" / "&" / "'" / "*" / "+" / "-" / "." / "^" / "_" / "`" / "|" / "~" / DIGIT / ALPHA
+
+Ref - https://www.rfc-editor.org/rfc/rfc9110#section-5.6.2
+*/
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for index := 0; index < len(s); index++ {
+		c := s[index]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Reports if the field value only has the allowed characters. Control characters other than HTAB are refused, NUL, CR and LF
+included.
+
+	field-value = *( VCHAR / obs-text / SP / HTAB )
+*/
+func isValidFieldValue(value string) bool {
+	for index := 0; index < len(value); index++ {
+		c := value[index]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHexDigit(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
+
+// Reports if the byte is optional whitespace (OWS), a space or a horizontal tab.
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
@@ -1,16 +1,77 @@
 package httperr
 
-import "errors"
+import (
+	"errors"
+	"gopherreq/gopherreq/common"
+)
+
+// HTTPError is an error which is answered to the client with the status code and the reason phrase.
+type HTTPError struct {
+	Code    common.StatusCode // The status code sent to the client.
+	Reason  string            // The reason phrase sent to the client.
+	message string
+}
+
+func NewHTTPError(code common.StatusCode, reason string, message string) *HTTPError {
+	return &HTTPError{
+		Code:    code,
+		Reason:  reason,
+		message: message,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return e.message
+}
+
+// Returns the HTTPError wrapped in the error chain if any.
+func AsHTTPError(err error) (httpErr *HTTPError, ok bool) {
+	ok = errors.As(err, &httpErr)
+
+	return
+}
 
 // Shared Errors
 var (
-	ErrIncompleteHeader    = errors.New("incomplete headers")
-	ErrHeaderLimitExceeded = errors.New("size of headers exceeds the limit")
-	ErrInvalidHttpMethod   = errors.New("invalid http method")
+	ErrIncompleteHeader       = errors.New("incomplete headers")
+	ErrHeaderLimitExceeded    = NewHTTPError(431, "Request Header Fields Too Large", "size of headers exceeds the limit")
+	ErrInvalidHttpMethod      = NewHTTPError(501, "Not Implemented", "invalid http method")
+	ErrUnsupportedHttpVersion = NewHTTPError(505, "HTTP Version Not Supported", "http version is not supported")
+)
+
+// Server Errors
+var (
+	ErrServerClosed = errors.New("server closed")
 )
 
 // Http Request Errors
 var (
-	ErrInvalidContentLength = errors.New("content length is invalid")
-	ErrInvalidRequestLine   = errors.New("invalid request line")
+	ErrInvalidContentLength      = NewHTTPError(400, "Bad Request", "content length is invalid")
+	ErrAmbiguousLength           = NewHTTPError(400, "Bad Request", "both transfer encoding and content length are set")
+	ErrInvalidTransferCoding     = NewHTTPError(400, "Bad Request", "transfer encoding is invalid")
+	ErrUnsupportedTransferCoding = NewHTTPError(501, "Not Implemented", "transfer coding is not implemented")
+	ErrInvalidRequestLine        = NewHTTPError(400, "Bad Request", "invalid request line")
+	ErrInvalidHeader             = NewHTTPError(400, "Bad Request", "invalid header field")
+	ErrInvalidHost               = NewHTTPError(400, "Bad Request", "host is missing or invalid")
+	ErrInvalidChunkedBody        = NewHTTPError(400, "Bad Request", "chunked body is malformed")
+	ErrTrailerLimitExceeded      = NewHTTPError(431, "Request Header Fields Too Large", "size of trailers exceeds the limit")
+	ErrRequestHeaderTimeout      = NewHTTPError(408, "Request Timeout", "timed out while reading the request headers")
+	ErrRequestBodyTimeout        = NewHTTPError(408, "Request Timeout", "timed out while reading the request body")
+)
+
+// Http Response Errors
+var (
+	ErrBodyNotAllowed     = errors.New("response status does not allow a body")
+	ErrContentLengthLimit = NewHTTPError(500, "Internal Server Error", "response body exceeds the declared content length")
+	ErrHandlerTimeout     = NewHTTPError(503, "Service Unavailable", "handler did not finish before the deadline")
+	ErrHandlerPanic       = NewHTTPError(500, "Internal Server Error", "handler panicked")
+	ErrAbortHandler       = errors.New("handler aborted") // Panic with it to stop the handler and close the connection without logging.
+)
+
+// Client Errors
+var (
+	ErrUnsupportedScheme = errors.New("unsupported url scheme")
+	ErrInvalidRequest    = errors.New("invalid request")
+	ErrInvalidResponse   = errors.New("malformed response")
+	ErrTooManyRedirects  = errors.New("stopped after too many redirects")
 )
@@ -0,0 +1,189 @@
+package gopherreq
+
+import (
+	"errors"
+	"fmt"
+	"gopherreq/gopherreq/httperr"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The address used when the config does not provide any address or listener.
+const DEFAULT_ADDRESS = "127.0.0.1:8811"
+
+const unixAddressPrefix = "unix:"
+
+/*
+Converts a listen address to the network and address understood by net.Listen.
+
+	host:port, [ipv6]:port  -> A TCP socket.
+	unix:/path/to/file.sock -> A unix socket on the file system.
+	unix:@name              -> A unix socket in the abstract namespace (Linux only).
+*/
+func parseListenAddress(address string) (network string, addr string, err error) {
+	if rest, isUnix := strings.CutPrefix(address, unixAddressPrefix); isUnix {
+		if rest == "" || rest == "@" {
+			err = fmt.Errorf("gopherreq: unix listen address %q has no path", address)
+			return
+		}
+
+		return "unix", rest, nil
+	}
+
+	if _, _, err = net.SplitHostPort(address); err != nil {
+		err = fmt.Errorf("gopherreq: invalid listen address %q: %w", address, err)
+		return
+	}
+
+	return "tcp", address, nil
+}
+
+// Opens a listener for the address. A socket file left over by a process which is not running anymore is removed first.
+func listen(address string) (listener net.Listener, err error) {
+	network, addr, err := parseListenAddress(address)
+	if err != nil {
+		return
+	}
+
+	if network == "unix" && !strings.HasPrefix(addr, "@") {
+		removeStaleSocket(addr)
+	}
+
+	return net.Listen(network, addr)
+}
+
+// Removes the socket file if nobody is accepting connections on it anymore. Regular files are never touched.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return
+	}
+
+	os.Remove(path)
+}
+
+// Opens a listener for every address. If any of them fails the ones already opened are closed.
+func listenAll(addresses []string) (listeners []net.Listener, err error) {
+	for _, address := range addresses {
+		listener, listenErr := listen(address)
+		if listenErr != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, listenErr
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return
+}
+
+// Returns the addresses the server is listening on.
+func (s *HttpServer) Addrs() (addrs []net.Addr) {
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+
+	return
+}
+
+/*
+Accepts the connections on every listener and serves each of them in its own goroutine.
+
+It blocks until all the listeners are closed. It returns httperr.ErrServerClosed after Shutdown, otherwise the first error which
+stopped a listener.
+*/
+func (s *HttpServer) Listen() error {
+	errs := make([]error, len(s.listeners))
+
+	var wg sync.WaitGroup
+	for index, listener := range s.listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[index] = s.serve(listener)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, httperr.ErrServerClosed) {
+			return err
+		}
+	}
+
+	return httperr.ErrServerClosed
+}
+
+func (s *HttpServer) serve(listener net.Listener) error {
+
+	acceptDelay := time.Duration(0)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return httperr.ErrServerClosed
+			}
+
+			// Errors like running out of file descriptors go away once connections are closed so back off and try again.
+			if isTemporaryAcceptError(err) {
+				acceptDelay = min(max(2*acceptDelay, 5*time.Millisecond), time.Second)
+				s.errorLog.Warn("error while accepting the connection", "error", err, "retry_in", acceptDelay)
+				time.Sleep(acceptDelay)
+				continue
+			}
+
+			return err
+		}
+
+		acceptDelay = 0
+
+		// The shutdown might have started since the connection was accepted.
+		if !s.setConnState(conn, stateNew) {
+			conn.Close()
+			return httperr.ErrServerClosed
+		}
+
+		go s.handleConnection(conn)
+	}
+}
+
+/*
+Reports if the accept failure only concerns the connection being accepted or a shortage of resources. The listener itself is
+still usable so the server keeps accepting.
+
+	EMFILE, ENFILE  : The process or the system ran out of file descriptors.
+	ENOBUFS, ENOMEM : The kernel ran out of memory for the socket.
+	ECONNABORTED    : The client reset the connection before it was accepted.
+*/
+func isTemporaryAcceptError(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ENOMEM) || errors.Is(err, syscall.ECONNABORTED)
+}
+
+func (s *HttpServer) closeListeners() (err error) {
+	for _, listener := range s.listeners {
+		closeErr := listener.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	return
+}
@@ -0,0 +1,110 @@
+package gopherreq
+
+import (
+	"fmt"
+	"gopherreq/gopherreq/common"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	methodsMu sync.RWMutex
+
+	// The methods accepted in the request line. Requests with any other method are answered with 501.
+	supportedHttpMethods = []common.HttpMethod{
+		common.Get, common.Head, common.Post, common.Put, common.Patch, common.Delete, common.Options, common.Trace, common.Connect,
+	}
+)
+
+/*
+RegisterMethod adds an extension method, like PROPFIND or PURGE, to the methods accepted by the servers. Routes for it are added
+with Router.Handle.
+
+Methods are case sensitive and must be a token. It panics when the method is not valid, like the router does for invalid
+patterns, since it is a programming error.
+*/
+func RegisterMethod(method common.HttpMethod) {
+	if !isToken(string(method)) {
+		panic(fmt.Sprintf("gopherreq: invalid method %q", method))
+	}
+
+	methodsMu.Lock()
+	defer methodsMu.Unlock()
+
+	if !slices.Contains(supportedHttpMethods, method) {
+		supportedHttpMethods = append(supportedHttpMethods, method)
+	}
+}
+
+// Reports if the method is one of the standard methods or was registered with RegisterMethod.
+func IsSupportedMethod(method common.HttpMethod) bool {
+	methodsMu.RLock()
+	defer methodsMu.RUnlock()
+
+	return slices.Contains(supportedHttpMethods, method)
+}
+
+// Returns the methods accepted by the server, in the order they were added.
+func SupportedMethods() []common.HttpMethod {
+	methodsMu.RLock()
+	defer methodsMu.RUnlock()
+
+	return slices.Clone(supportedHttpMethods)
+}
+
+// Answers "OPTIONS *" which asks about the capabilities of the server rather than of a resource.
+func serveServerOptions(w ResponseWriter, req *HttpRequest) {
+	methods := []string{}
+	for _, method := range SupportedMethods() {
+		methods = append(methods, string(method))
+	}
+
+	w.Header().Set("Allow", HeaderValue(strings.Join(methods, ", ")))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(OK)
+}
+
+func serveTraceDisabled(w ResponseWriter, req *HttpRequest) {
+	methods := []string{}
+	for _, method := range SupportedMethods() {
+		if method != common.Trace {
+			methods = append(methods, string(method))
+		}
+	}
+
+	w.Header().Set("Allow", HeaderValue(strings.Join(methods, ", ")))
+	Error(w, METHOD_NOT_ALLOWED)
+}
+
+// Fields left out of the TRACE echo since they hold credentials. Ref - https://www.rfc-editor.org/rfc/rfc9110#section-9.3.8
+var traceExcludedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+/*
+Answers TRACE by sending back the request as received, which lets a client see what the proxies on the way changed. The body
+of the request is not part of the echo.
+*/
+func serveTrace(w ResponseWriter, req *HttpRequest) {
+	echo := strings.Builder{}
+	echo.WriteString(fmt.Sprintf("%s %s %s%s", req.Method, req.RawURI, req.Version, common.CRLF))
+
+	keys := []string{}
+	for key := range req.Headers {
+		if !slices.Contains(traceExcludedHeaders, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		for _, value := range req.Headers[key] {
+			echo.WriteString(fmt.Sprintf("%s: %s%s", key, value, common.CRLF))
+		}
+	}
+
+	echo.WriteString(common.CRLF)
+
+	w.Header().Set("Content-Type", "message/http")
+	w.WriteHeader(OK)
+	w.Write([]byte(echo.String()))
+}
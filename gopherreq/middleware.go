@@ -0,0 +1,212 @@
+package gopherreq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a handler to run code before and after it.
+type Middleware func(next Handler) Handler
+
+// Wraps the handler with the middlewares. The first middleware is the outermost one and sees the request first.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for index := len(middlewares) - 1; index >= 0; index-- {
+		handler = middlewares[index](handler)
+	}
+
+	return handler
+}
+
+// Implemented by the writers of this package which can still throw away a response that has not been sent.
+type responseResetter interface {
+	resetResponse() bool
+}
+
+// Discards the status, headers and body written so far if none of it reached the client. It reports if the response was reset.
+func resetResponse(w ResponseWriter) bool {
+	resetter, ok := w.(responseResetter)
+
+	return ok && resetter.resetResponse()
+}
+
+/*
+Recovery turns a panic in the handler into a 500 response so the connection can keep serving requests.
+
+If part of the response was already sent it can not be replaced, so the handler is aborted with httperr.ErrAbortHandler and the
+server closes the connection.
+*/
+func Recovery(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			if recovered == httperr.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			req.Logger().Error("panic serving request", "panic", recovered, "stack", string(debug.Stack()))
+
+			if !resetResponse(w) {
+				panic(httperr.ErrAbortHandler)
+			}
+
+			Error(w, INTERNAL_SERVER_ERROR)
+		}()
+
+		next.ServeHttp(w, req)
+	})
+}
+
+// The header carrying the request ID between the client, the server and the upstream services.
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// Longest request ID accepted from the client. Longer ones are replaced by a generated ID.
+const MAX_REQUEST_ID_LENGTH = 128
+
+/*
+RequestID makes sure every request has an ID.
+
+The ID sent by the client in X-Request-Id is reused if it is safe to log, otherwise a random one is generated. It is stored in
+HttpRequest.RequestID and echoed in the X-Request-Id response header.
+*/
+func RequestID(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+		id := req.Headers.Get(REQUEST_ID_HEADER).String()
+
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+
+		req.RequestID = id
+		w.Header().Set(REQUEST_ID_HEADER, HeaderValue(id))
+
+		next.ServeHttp(w, req)
+	})
+}
+
+// Only visible ASCII characters are accepted so the ID can not break log lines or headers.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+
+	return strings.IndexFunc(id, func(r rune) bool {
+		return r <= ' ' || r > '~' || r == '"'
+	}) < 0
+}
+
+func newRequestID() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+
+	return hex.EncodeToString(raw)
+}
+
+/*
+AccessLogger writes a line for every request to the output in the format. Use it to log only some routes, the server wide
+access log is configured with Config.AccessLog.
+
+	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+*/
+func AccessLogger(out io.Writer, format AccessLogFormat) Middleware {
+	log := newAccessLog(AccessLogConfig{Format: format, Output: out})
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+			start := time.Now()
+			recorder := newResponseRecorder(w)
+
+			completed := false
+
+			// Deferred so the requests aborted by a panic are logged too. They are logged as a 500 whatever the handler wrote.
+			defer func() {
+				status := recorder.Status()
+				if !completed {
+					status = INTERNAL_SERVER_ERROR
+				}
+
+				log.write(newAccessLogEntry(req, start, status, recorder.BytesWritten()))
+			}()
+
+			next.ServeHttp(recorder, req)
+			completed = true
+		})
+	}
+}
+
+// Returns the host part of a remote address or "-" when unknown, like for unix sockets.
+func remoteHost(remoteAddr string) string {
+	if remoteAddr == "" || remoteAddr == "@" {
+		return "-"
+	}
+
+	if index := strings.LastIndex(remoteAddr, ":"); index != -1 {
+		return strings.Trim(remoteAddr[:index], "[]")
+	}
+
+	return remoteAddr
+}
+
+// Wraps a ResponseWriter to remember the status and the number of body bytes written by the handler.
+type responseRecorder struct {
+	ResponseWriter
+	status  common.StatusCode
+	written int64
+}
+
+func newResponseRecorder(w ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (r *responseRecorder) WriteHeader(code common.StatusCode) {
+	if r.status == 0 {
+		r.status = code
+	}
+
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = OK
+	}
+
+	n, err := r.ResponseWriter.Write(data)
+	r.written += int64(n)
+
+	return n, err
+}
+
+func (r *responseRecorder) resetResponse() bool {
+	if !resetResponse(r.ResponseWriter) {
+		return false
+	}
+
+	r.status = 0
+	r.written = 0
+
+	return true
+}
+
+// Returns the status sent to the client. It is 200 if the handler did not set one.
+func (r *responseRecorder) Status() common.StatusCode {
+	if r.status == 0 {
+		return OK
+	}
+
+	return r.status
+}
+
+// Returns the number of body bytes written by the handler.
+func (r *responseRecorder) BytesWritten() int64 {
+	return r.written
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"gopherreq/gopherreq"
+	"hash/fnv"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Points of every unit of weight on the ring of the consistent hash. More points spread the keys more evenly.
+const HASH_RING_REPLICAS = 100
+
+/*
+Balancer picks the upstream of a request. It is called with the available upstreams of the pool, in the order of the config,
+and must be safe for concurrent use. A balancer keeps state about the upstreams so it must not be shared between pools.
+*/
+type Balancer interface {
+	Pick(upstreams []*Upstream, req *gopherreq.HttpRequest) *Upstream
+}
+
+// HashKey returns the key of the request for the consistent hash. An empty key means the request has none.
+type HashKey func(req *gopherreq.HttpRequest) string
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// Sends the requests to each upstream in turn, ignoring the weights.
+func NewRoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(upstreams []*Upstream, req *gopherreq.HttpRequest) *Upstream {
+	return upstreams[(b.next.Add(1)-1)%uint64(len(upstreams))]
+}
+
+/*
+Sends the requests to each upstream in turn in proportion to their weights. The picks are interleaved, so with the weights 5, 1
+and 1 the order is a a b a c a a rather than five times a in a row.
+
+Every pick adds the weight of each upstream to its current score, takes the highest score and lowers it by the total of the
+weights. This is the smooth weighted round robin of nginx.
+*/
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Upstream]int
+}
+
+func NewWeightedRoundRobin() Balancer {
+	return &weightedRoundRobin{current: make(map[*Upstream]int)}
+}
+
+func (b *weightedRoundRobin) Pick(upstreams []*Upstream, req *gopherreq.HttpRequest) *Upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The score of an upstream which left, ejected or unhealthy, is dropped so it comes back from zero instead of taking a burst
+	// of requests with the score it had.
+	for upstream := range b.current {
+		if !slices.Contains(upstreams, upstream) {
+			delete(b.current, upstream)
+		}
+	}
+
+	var best *Upstream
+	total := 0
+
+	for _, upstream := range upstreams {
+		b.current[upstream] += upstream.Weight
+		total += upstream.Weight
+
+		if best == nil || b.current[upstream] > b.current[best] {
+			best = upstream
+		}
+	}
+
+	b.current[best] -= total
+
+	return best
+}
+
+type leastConnections struct {
+	next atomic.Uint64
+}
+
+/*
+Sends the request to the upstream with the fewest requests in flight relative to its weight. The ties are broken in turn so
+an idle pool is still used evenly.
+*/
+func NewLeastConnections() Balancer {
+	return &leastConnections{}
+}
+
+func (b *leastConnections) Pick(upstreams []*Upstream, req *gopherreq.HttpRequest) *Upstream {
+	start := int((b.next.Add(1) - 1) % uint64(len(upstreams)))
+
+	var best *Upstream
+	var bestActive int64
+
+	for index := range upstreams {
+		upstream := upstreams[(start+index)%len(upstreams)]
+		active := upstream.ActiveRequests()
+
+		// active / weight < bestActive / best.Weight without the division.
+		if best == nil || active*int64(best.Weight) < bestActive*int64(upstream.Weight) {
+			best = upstream
+			bestActive = active
+		}
+	}
+
+	return best
+}
+
+/*
+Sends the requests with the same key to the same upstream, like every request of a user to the server which has its data in
+cache. The upstreams are placed on a ring of hashes, HASH_RING_REPLICAS points per unit of weight, and the key goes to the first
+point after its hash. When an upstream leaves or comes back only its share of the keys moves.
+
+The requests without a key are sent to each upstream in turn.
+*/
+type consistentHash struct {
+	key      HashKey
+	fallback roundRobin
+
+	mu     sync.Mutex
+	points []ringPoint
+	ring   []*Upstream // The upstreams the ring was built for.
+}
+
+type ringPoint struct {
+	hash     uint64
+	upstream *Upstream
+}
+
+func NewConsistentHash(key HashKey) Balancer {
+	return &consistentHash{key: key}
+}
+
+// Uses the value of the request header as the key.
+func HashByHeader(name string) HashKey {
+	return func(req *gopherreq.HttpRequest) string {
+		return req.Headers.Get(name).String()
+	}
+}
+
+// Uses the value of the request cookie as the key.
+func HashByCookie(name string) HashKey {
+	return func(req *gopherreq.HttpRequest) string {
+		c, exists := req.Cookies.Get(name)
+		if !exists {
+			return ""
+		}
+
+		return c.Value
+	}
+}
+
+// Uses the address of the client as the key. Behind another proxy every request comes from the same address, hash by a header then.
+func HashByClientIP() HashKey {
+	return func(req *gopherreq.HttpRequest) string {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+
+		return host
+	}
+}
+
+func (b *consistentHash) Pick(upstreams []*Upstream, req *gopherreq.HttpRequest) *Upstream {
+	key := b.key(req)
+	if key == "" {
+		return b.fallback.Pick(upstreams, req)
+	}
+
+	points := b.ringFor(upstreams)
+	hash := hashString(key)
+
+	index, _ := slices.BinarySearchFunc(points, hash, func(point ringPoint, hash uint64) int {
+		switch {
+		case point.hash < hash:
+			return -1
+		case point.hash > hash:
+			return 1
+		}
+		return 0
+	})
+
+	if index == len(points) {
+		index = 0
+	}
+
+	return points[index].upstream
+}
+
+// Returns the ring of the upstreams. It is only built again when the available upstreams change.
+func (b *consistentHash) ringFor(upstreams []*Upstream) []ringPoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if slices.Equal(b.ring, upstreams) {
+		return b.points
+	}
+
+	points := []ringPoint{}
+	for _, upstream := range upstreams {
+		base := upstream.URL.String() + "#"
+		for replica := 0; replica < HASH_RING_REPLICAS*upstream.Weight; replica++ {
+			points = append(points, ringPoint{hash: hashString(base + strconv.Itoa(replica)), upstream: upstream})
+		}
+	}
+
+	slices.SortFunc(points, func(a ringPoint, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return 0
+	})
+
+	b.ring = slices.Clone(upstreams)
+	b.points = points
+
+	return points
+}
+
+// FNV alone spreads keys which only differ at the end poorly, like the replicas of an upstream, so its result is mixed again with the finalizer of SplitMix64.
+func hashString(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+
+	mixed := hash.Sum64()
+	mixed = (mixed ^ (mixed >> 30)) * 0xbf58476d1ce4e5b9
+	mixed = (mixed ^ (mixed >> 27)) * 0x94d049bb133111eb
+
+	return mixed ^ (mixed >> 31)
+}
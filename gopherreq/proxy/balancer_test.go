@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"gopherreq/gopherreq"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Returns the upstreams http://a.test, http://b.test and so on with the weights.
+func testUpstreams(t *testing.T, weights ...int) []*Upstream {
+	t.Helper()
+
+	cfg := PoolConfig{}
+	for index, weight := range weights {
+		cfg.Upstreams = append(cfg.Upstreams, UpstreamConfig{URL: "http://" + string(rune('a'+index)) + ".test", Weight: weight})
+	}
+
+	pool, err := NewPool(cfg)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	return pool.Upstreams()
+}
+
+// The short name of the upstream, "a" for http://a.test.
+func upstreamName(upstream *Upstream) string {
+	return strings.TrimSuffix(upstream.URL.Host, ".test")
+}
+
+func pickNames(balancer Balancer, upstreams []*Upstream, count int) string {
+	names := []string{}
+	for range count {
+		names = append(names, upstreamName(balancer.Pick(upstreams, &gopherreq.HttpRequest{})))
+	}
+
+	return strings.Join(names, " ")
+}
+
+func TestRoundRobin(t *testing.T) {
+	upstreams := testUpstreams(t, 5, 1, 1)
+
+	if got := pickNames(NewRoundRobin(), upstreams, 6); got != "a b c a b c" {
+		t.Fatalf("picks = %q, want the weights ignored", got)
+	}
+}
+
+func TestWeightedRoundRobinOrder(t *testing.T) {
+	upstreams := testUpstreams(t, 5, 1, 1)
+	balancer := NewWeightedRoundRobin()
+
+	// The order repeats after a full cycle of the total weight.
+	for cycle := range 3 {
+		if got := pickNames(balancer, upstreams, 7); got != "a a b a c a a" {
+			t.Fatalf("cycle %d picks = %q, want \"a a b a c a a\"", cycle, got)
+		}
+	}
+}
+
+// An upstream which left the rotation comes back from zero instead of the score it left with.
+func TestWeightedRoundRobinReturningUpstream(t *testing.T) {
+	upstreams := testUpstreams(t, 5, 1, 1)
+	balancer := NewWeightedRoundRobin().(*weightedRoundRobin)
+
+	pickNames(balancer, upstreams, 2)
+
+	if got := pickNames(balancer, upstreams[1:], 4); got != "b c b c" {
+		t.Fatalf("picks without a = %q, want \"b c b c\"", got)
+	}
+
+	if score, kept := balancer.current[upstreams[0]]; kept {
+		t.Fatalf("the score %d of the upstream which left was kept", score)
+	}
+
+	// Back with a fresh score a leads again, with b and c interleaved as in a fresh cycle.
+	if got := pickNames(balancer, upstreams, 7); strings.Count(got, "a") != 5 || strings.HasPrefix(got, "a a a") {
+		t.Fatalf("picks once a is back = %q, want 5 interleaved picks of a", got)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	upstreams := testUpstreams(t, 1, 1, 1)
+	balancer := NewLeastConnections()
+
+	// The ties of an idle pool are broken in turn.
+	if got := pickNames(balancer, upstreams, 6); got != "a b c a b c" {
+		t.Fatalf("idle picks = %q, want \"a b c a b c\"", got)
+	}
+
+	upstreams[0].active.Add(2)
+	upstreams[1].active.Add(1)
+
+	if got := pickNames(balancer, upstreams, 3); got != "c c c" {
+		t.Fatalf("picks = %q, want the least busy c", got)
+	}
+
+	upstreams[2].active.Add(1)
+
+	// b and c are tied and still rotate, a stays the busiest.
+	if got := pickNames(balancer, upstreams, 4); strings.Contains(got, "a") || !strings.Contains(got, "b") || !strings.Contains(got, "c") {
+		t.Fatalf("picks = %q, want b and c in turn", got)
+	}
+}
+
+// The requests in flight are compared relative to the weights.
+func TestLeastConnectionsWeighted(t *testing.T) {
+	upstreams := testUpstreams(t, 4, 1)
+	balancer := NewLeastConnections()
+
+	upstreams[0].active.Add(3)
+	upstreams[1].active.Add(1)
+
+	if got := pickNames(balancer, upstreams, 2); got != "a a" {
+		t.Fatalf("picks = %q, want a with 3/4 over b with 1/1", got)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	upstreams := testUpstreams(t, 1, 1, 1)
+	balancer := NewConsistentHash(HashByHeader("X-User"))
+
+	pick := func(upstreams []*Upstream, user string) *Upstream {
+		req := &gopherreq.HttpRequest{Headers: gopherreq.Headers{}}
+		req.Headers.Set("X-User", gopherreq.HeaderValue(user))
+		return balancer.Pick(upstreams, req)
+	}
+
+	before := map[string]*Upstream{}
+	counts := map[*Upstream]int{}
+	for index := range 3000 {
+		user := "user-" + strconv.Itoa(index)
+		before[user] = pick(upstreams, user)
+		counts[before[user]]++
+
+		if again := pick(upstreams, user); again != before[user] {
+			t.Fatalf("%s moved from %s to %s without any change", user, upstreamName(before[user]), upstreamName(again))
+		}
+	}
+
+	for _, upstream := range upstreams {
+		if counts[upstream] < 600 {
+			t.Fatalf("upstream %s got %d of 3000 keys, want a fair share", upstreamName(upstream), counts[upstream])
+		}
+	}
+
+	// Removing b only moves the keys of b.
+	remaining := []*Upstream{upstreams[0], upstreams[2]}
+	for user, previous := range before {
+		now := pick(remaining, user)
+
+		if previous != upstreams[1] && now != previous {
+			t.Fatalf("%s moved from %s to %s when b left", user, upstreamName(previous), upstreamName(now))
+		}
+		if now == upstreams[1] {
+			t.Fatalf("%s still goes to the removed b", user)
+		}
+	}
+
+	// And b coming back takes exactly its keys again.
+	for user, previous := range before {
+		if now := pick(upstreams, user); now != previous {
+			t.Fatalf("%s went to %s instead of %s once b was back", user, upstreamName(now), upstreamName(previous))
+		}
+	}
+}
+
+// The requests without a key are spread in turn.
+func TestConsistentHashWithoutKey(t *testing.T) {
+	upstreams := testUpstreams(t, 1, 1, 1)
+	balancer := NewConsistentHash(HashByHeader("X-User"))
+
+	if got := pickNames(balancer, upstreams, 3); got != "a b c" {
+		t.Fatalf("picks = %q, want round robin", got)
+	}
+}
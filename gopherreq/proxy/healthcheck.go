@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/common"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time between two health checks when the config does not set it.
+const DEFAULT_HEALTH_CHECK_INTERVAL = 10 * time.Second
+
+// Time for an upstream to answer a health check when the config does not set it.
+const DEFAULT_HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+type HealthCheckConfig struct {
+	Path     string        // The path requested on every upstream, like /healthz. It is required.
+	Interval time.Duration // Time between two checks. Defaults to DEFAULT_HEALTH_CHECK_INTERVAL.
+	Timeout  time.Duration // Time for the upstream to answer. Defaults to DEFAULT_HEALTH_CHECK_TIMEOUT.
+}
+
+// Requests the health check path of every upstream on an interval. An upstream is healthy when it answers with 2xx or 3xx.
+type healthChecker struct {
+	path     string
+	interval time.Duration
+	client   *gopherreq.Client
+}
+
+func newHealthChecker(cfg HealthCheckConfig, transport *gopherreq.Transport) (*healthChecker, error) {
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, errors.New("proxy: the health check path must start with /")
+	}
+
+	checker := &healthChecker{
+		path:     cfg.Path,
+		interval: cfg.Interval,
+	}
+
+	if checker.interval <= 0 {
+		checker.interval = DEFAULT_HEALTH_CHECK_INTERVAL
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_HEALTH_CHECK_TIMEOUT
+	}
+
+	checker.client = &gopherreq.Client{
+		Transport:    transport,
+		Timeout:      timeout,
+		MaxRedirects: -1,
+		MaxRetries:   -1,
+	}
+
+	return checker, nil
+}
+
+// Checks every upstream right away and then on every interval until the pool is closed.
+func (c *healthChecker) run(p *Pool) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.checkAll(p)
+
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (c *healthChecker) checkAll(p *Pool) {
+	wg := sync.WaitGroup{}
+
+	for _, upstream := range p.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := c.check(upstream)
+
+			upstream.mu.Lock()
+			upstream.healthy = err == nil
+			upstream.lastCheck = time.Now()
+			upstream.lastError = ""
+			if err != nil {
+				upstream.lastError = err.Error()
+			}
+			upstream.mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (c *healthChecker) check(upstream *Upstream) error {
+	target := *upstream.URL
+	target.Path = c.path
+	target.RawPath = ""
+	target.RawQuery = ""
+
+	if path, query, found := strings.Cut(c.path, "?"); found {
+		target.Path = path
+		target.RawQuery = query
+	}
+
+	resp, err := c.client.Do(gopherreq.HttpRequest{Method: common.Get, URI: target})
+	if err != nil {
+		return err
+	}
+
+	_, err = resp.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("health check answered %d", resp.StatusCode)
+	}
+
+	return nil
+}
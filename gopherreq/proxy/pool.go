@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/common"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Consecutive failures of an upstream before it is ejected when the config does not set it.
+const DEFAULT_MAX_FAILURES = 3
+
+// Time the upstream is ejected for the first time when the config does not set it.
+const DEFAULT_EJECTION_TIME = 10 * time.Second
+
+// Longest ejection of an upstream when the config does not set it.
+const DEFAULT_MAX_EJECTION_TIME = 5 * time.Minute
+
+// Returned when every upstream of the pool is down or ejected.
+var ErrNoUpstream = errors.New("proxy: no upstream available")
+
+type UpstreamConfig struct {
+	URL    string // The base URI of the upstream like http://10.0.0.1:8080/api.
+	Weight int    // The share of the requests it gets compared to the others. Defaults to 1.
+}
+
+type PoolConfig struct {
+	Upstreams   []UpstreamConfig   // At least one is required.
+	Balancer    Balancer           // Picks the upstream of every request. Defaults to NewRoundRobin().
+	HealthCheck *HealthCheckConfig // Enables the active health checks. Without it only the failures of the requests are watched.
+	Transport   *gopherreq.Transport
+
+	MaxFailures     int           // Consecutive failed requests before the upstream is ejected. Defaults to DEFAULT_MAX_FAILURES. Negative never ejects.
+	EjectionTime    time.Duration // Time of the first ejection, doubled every time the upstream fails again once back. Defaults to DEFAULT_EJECTION_TIME.
+	MaxEjectionTime time.Duration // Longest ejection. Defaults to DEFAULT_MAX_EJECTION_TIME.
+
+	FailureStatuses []common.StatusCode // Response statuses which count as a failed request. Defaults to 502, 503 and 504.
+}
+
+/*
+Pool spreads the requests over a set of upstreams with its Balancer and keeps track of their health.
+
+An upstream is taken out of the rotation when it fails the active health checks, or when MaxFailures requests in a row failed
+to get a response from it or got one of the FailureStatuses (passive ejection). An ejected upstream is given another chance after the ejection time. If its next
+request fails as well it is ejected again for twice as long, up to MaxEjectionTime, while a successful one restores it fully.
+
+The health checks run in the background until Close is called.
+*/
+type Pool struct {
+	upstreams       []*Upstream
+	balancer        Balancer
+	maxFailures     int
+	ejectionTime    time.Duration
+	maxEjectionTime time.Duration
+	failureStatuses []common.StatusCode
+	done            chan struct{}
+	once            sync.Once
+}
+
+// Upstream is a server of the pool along with its health.
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+
+	active atomic.Int64 // Requests in flight.
+
+	mu           sync.Mutex
+	healthy      bool      // The result of the last active health check. It is true until the first check.
+	failures     int       // Consecutive failed requests.
+	ejections    int       // Consecutive ejections, used to double the ejection time.
+	ejectedUntil time.Time // The upstream gets no request until then.
+	lastCheck    time.Time
+	lastError    string
+}
+
+/*
+UpstreamStatus is the state of an upstream as listed by the status endpoint.
+
+	{"url":"http://10.0.0.1:8080","weight":1,"available":true,"healthy":true,"ejected":false,"active_requests":2,"consecutive_failures":0}
+*/
+type UpstreamStatus struct {
+	URL                 string     `json:"url"`
+	Weight              int        `json:"weight"`
+	Available           bool       `json:"available"`
+	Healthy             bool       `json:"healthy"`
+	Ejected             bool       `json:"ejected"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	ActiveRequests      int64      `json:"active_requests"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+func NewPool(cfg PoolConfig) (*Pool, error) {
+	if len(cfg.Upstreams) == 0 {
+		return nil, errors.New("proxy: at least one upstream is required")
+	}
+
+	p := &Pool{
+		balancer:        cfg.Balancer,
+		maxFailures:     cfg.MaxFailures,
+		ejectionTime:    cfg.EjectionTime,
+		maxEjectionTime: cfg.MaxEjectionTime,
+		failureStatuses: cfg.FailureStatuses,
+		done:            make(chan struct{}),
+	}
+
+	for _, upstreamCfg := range cfg.Upstreams {
+		upstream, err := parseUpstream(upstreamCfg.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		weight := upstreamCfg.Weight
+		if weight < 0 {
+			return nil, fmt.Errorf("proxy: negative weight for upstream %q", upstreamCfg.URL)
+		}
+		if weight == 0 {
+			weight = 1
+		}
+
+		p.upstreams = append(p.upstreams, &Upstream{URL: upstream, Weight: weight, healthy: true})
+	}
+
+	if p.balancer == nil {
+		p.balancer = NewRoundRobin()
+	}
+	if p.maxFailures == 0 {
+		p.maxFailures = DEFAULT_MAX_FAILURES
+	}
+	if p.ejectionTime <= 0 {
+		p.ejectionTime = DEFAULT_EJECTION_TIME
+	}
+	if p.maxEjectionTime <= 0 {
+		p.maxEjectionTime = DEFAULT_MAX_EJECTION_TIME
+	}
+	if p.failureStatuses == nil {
+		p.failureStatuses = []common.StatusCode{gopherreq.BAD_GATEWAY, gopherreq.SERVICE_UNAVAILABLE, gopherreq.GATEWAY_TIMEOUT}
+	}
+
+	if cfg.HealthCheck != nil {
+		checker, err := newHealthChecker(*cfg.HealthCheck, cfg.Transport)
+		if err != nil {
+			return nil, err
+		}
+
+		go checker.run(p)
+	}
+
+	return p, nil
+}
+
+func parseUpstream(rawUpstream string) (*url.URL, error) {
+	upstream, err := url.Parse(rawUpstream)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: invalid upstream %q: %w", rawUpstream, err)
+	}
+
+	if (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("proxy: upstream %q must be an absolute http or https URI", rawUpstream)
+	}
+
+	return upstream, nil
+}
+
+// Picks the upstream for the request among the available ones. It returns ErrNoUpstream when there is none.
+func (p *Pool) Pick(req *gopherreq.HttpRequest) (*Upstream, error) {
+	now := time.Now()
+
+	available := make([]*Upstream, 0, len(p.upstreams))
+	for _, upstream := range p.upstreams {
+		if upstream.available(now) {
+			available = append(available, upstream)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, ErrNoUpstream
+	}
+
+	upstream := p.balancer.Pick(available, req)
+	if upstream == nil {
+		return nil, ErrNoUpstream
+	}
+
+	return upstream, nil
+}
+
+// Returns the upstreams of the pool in the order of the config.
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// Records the outcome of a request sent to the upstream. Enough failures in a row eject it.
+func (p *Pool) report(upstream *Upstream, failed bool) {
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+
+	if !failed {
+		upstream.failures = 0
+		upstream.ejections = 0
+		return
+	}
+
+	upstream.failures++
+
+	// An upstream back from an ejection is ejected again on its first failure.
+	if p.maxFailures < 0 || (upstream.failures < p.maxFailures && upstream.ejections == 0) {
+		return
+	}
+
+	ejectionTime := p.maxEjectionTime
+	if upstream.ejections < 32 && p.ejectionTime<<upstream.ejections > 0 {
+		ejectionTime = min(p.ejectionTime<<upstream.ejections, p.maxEjectionTime)
+	}
+
+	upstream.ejections++
+	upstream.failures = 0
+	upstream.ejectedUntil = time.Now().Add(ejectionTime)
+}
+
+// Reports if a response with the status counts as a failed request of the upstream.
+func (p *Pool) isFailureStatus(status common.StatusCode) bool {
+	return slices.Contains(p.failureStatuses, status)
+}
+
+// Returns the state of every upstream in the order of the config.
+func (p *Pool) Status() []UpstreamStatus {
+	now := time.Now()
+	statuses := make([]UpstreamStatus, 0, len(p.upstreams))
+
+	for _, upstream := range p.upstreams {
+		upstream.mu.Lock()
+		status := UpstreamStatus{
+			URL:                 upstream.URL.String(),
+			Weight:              upstream.Weight,
+			Healthy:             upstream.healthy,
+			Ejected:             now.Before(upstream.ejectedUntil),
+			ActiveRequests:      upstream.active.Load(),
+			ConsecutiveFailures: upstream.failures,
+			LastError:           upstream.lastError,
+		}
+		if status.Ejected {
+			ejectedUntil := upstream.ejectedUntil
+			status.EjectedUntil = &ejectedUntil
+		}
+		if !upstream.lastCheck.IsZero() {
+			lastCheck := upstream.lastCheck
+			status.LastCheck = &lastCheck
+		}
+		status.Available = status.Healthy && !status.Ejected
+		upstream.mu.Unlock()
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Returns a handler which lists the state of every upstream as JSON. It is meant to be mounted on an internal route.
+func (p *Pool) StatusHandler() gopherreq.Handler {
+	return gopherreq.HandlerFunc(func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		body, err := json.Marshal(struct {
+			Upstreams []UpstreamStatus `json:"upstreams"`
+		}{p.Status()})
+		if err != nil {
+			gopherreq.Error(w, gopherreq.INTERNAL_SERVER_ERROR)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(body)
+	})
+}
+
+// Stops the health checks.
+func (p *Pool) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+	})
+
+	return nil
+}
+
+// Reports if the upstream is healthy and not ejected.
+func (u *Upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.healthy && !now.Before(u.ejectedUntil)
+}
+
+// Returns the number of requests the upstream is serving.
+func (u *Upstream) ActiveRequests() int64 {
+	return u.active.Load()
+}
@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/common"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Records the response of the handler in memory.
+type recorder struct {
+	headers gopherreq.Headers
+	code    common.StatusCode
+	body    bytes.Buffer
+}
+
+func (r *recorder) Header() gopherreq.Headers { return r.headers }
+
+func (r *recorder) WriteHeader(code common.StatusCode) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	r.WriteHeader(200)
+	return r.body.Write(data)
+}
+
+func (r *recorder) Flush() error { return nil }
+
+// Starts a server on a random local port with the handler and returns its base URI.
+func startUpstream(t *testing.T, handler gopherreq.HandlerFunc) string {
+	t.Helper()
+
+	server, err := gopherreq.NewServer(gopherreq.Config{Addresses: []string{"127.0.0.1:0"}, Handler: handler})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	go server.Listen()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+
+	return "http://" + server.Addrs()[0].String()
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Returns how long the upstream stays ejected, rounded to the second, or 0 when it is not ejected.
+func ejection(p *Pool, index int) time.Duration {
+	status := p.Status()[index]
+	if status.EjectedUntil == nil {
+		return 0
+	}
+
+	return time.Until(*status.EjectedUntil).Round(time.Second)
+}
+
+// Ends the ejection of the upstream as if its time had passed.
+func endEjection(upstream *Upstream) {
+	upstream.mu.Lock()
+	upstream.ejectedUntil = time.Time{}
+	upstream.mu.Unlock()
+}
+
+func TestPassiveEjection(t *testing.T) {
+	pool, err := NewPool(PoolConfig{
+		Upstreams:       []UpstreamConfig{{URL: "http://a.test"}, {URL: "http://b.test"}},
+		MaxFailures:     3,
+		EjectionTime:    10 * time.Second,
+		MaxEjectionTime: 25 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	upstream := pool.Upstreams()[0]
+
+	pool.report(upstream, true)
+	pool.report(upstream, true)
+	pool.report(upstream, false)
+	pool.report(upstream, true)
+	pool.report(upstream, true)
+
+	if got := ejection(pool, 0); got != 0 {
+		t.Fatalf("ejected for %v after failures interrupted by a success", got)
+	}
+
+	pool.report(upstream, true)
+
+	if got := ejection(pool, 0); got != 10*time.Second {
+		t.Fatalf("first ejection = %v, want 10s", got)
+	}
+
+	// The ejected upstream gets no request.
+	for range 4 {
+		if picked, err := pool.Pick(&gopherreq.HttpRequest{}); err != nil || picked == upstream {
+			t.Fatalf("Pick = %v, %v, want the other upstream", picked, err)
+		}
+	}
+
+	// Back from the ejection a single failure ejects it again for twice as long, up to the maximum.
+	for _, want := range []time.Duration{20 * time.Second, 25 * time.Second, 25 * time.Second} {
+		endEjection(upstream)
+		pool.report(upstream, true)
+
+		if got := ejection(pool, 0); got != want {
+			t.Fatalf("ejection = %v, want %v", got, want)
+		}
+	}
+
+	// A success restores it fully.
+	endEjection(upstream)
+	pool.report(upstream, false)
+	pool.report(upstream, true)
+
+	if got := ejection(pool, 0); got != 0 {
+		t.Fatalf("ejected for %v on the first failure after a success", got)
+	}
+
+	pool.report(upstream, true)
+	pool.report(upstream, true)
+
+	if got := ejection(pool, 0); got != 10*time.Second {
+		t.Fatalf("ejection after a success = %v, want 10s again", got)
+	}
+}
+
+func TestPassiveEjectionDisabled(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Upstreams: []UpstreamConfig{{URL: "http://a.test"}}, MaxFailures: -1})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	for range 100 {
+		pool.report(pool.Upstreams()[0], true)
+	}
+
+	if _, err := pool.Pick(&gopherreq.HttpRequest{}); err != nil {
+		t.Fatalf("Pick = %v, want the upstream never ejected", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var failing atomic.Bool
+	healthy := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		w.Write([]byte("ok"))
+	})
+	flaky := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		if req.URI.Path != "/healthz" || failing.Load() {
+			gopherreq.Error(w, gopherreq.SERVICE_UNAVAILABLE)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	pool, err := NewPool(PoolConfig{
+		Upstreams:   []UpstreamConfig{{URL: healthy}, {URL: flaky}},
+		HealthCheck: &HealthCheckConfig{Path: "/healthz", Interval: 10 * time.Millisecond, Timeout: time.Second},
+	})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer pool.Close()
+
+	checked := func() bool {
+		status := pool.Status()[1]
+		return status.LastCheck != nil
+	}
+	waitFor(t, "the first health check", checked)
+
+	if status := pool.Status()[1]; !status.Healthy || status.LastError != "" {
+		t.Fatalf("status = %+v, want healthy", status)
+	}
+
+	failing.Store(true)
+	waitFor(t, "the upstream to turn unhealthy", func() bool { return !pool.Status()[1].Healthy })
+
+	status := pool.Status()[1]
+	if status.Available || !strings.Contains(status.LastError, "503") {
+		t.Fatalf("status = %+v, want unavailable with the status in the error", status)
+	}
+
+	for range 4 {
+		if picked, err := pool.Pick(&gopherreq.HttpRequest{}); err != nil || picked.URL.String() != healthy {
+			t.Fatalf("Pick = %v, %v, want the healthy upstream", picked, err)
+		}
+	}
+
+	failing.Store(false)
+	waitFor(t, "the upstream to recover", func() bool { return pool.Status()[1].Available })
+
+	if status := pool.Status()[1]; status.LastError != "" {
+		t.Fatalf("last error = %q after the recovery, want none", status.LastError)
+	}
+}
+
+func TestHealthCheckAllDown(t *testing.T) {
+	down := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		gopherreq.Error(w, gopherreq.INTERNAL_SERVER_ERROR)
+	})
+
+	pool, err := NewPool(PoolConfig{
+		Upstreams:   []UpstreamConfig{{URL: down}},
+		HealthCheck: &HealthCheckConfig{Path: "/healthz", Interval: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer pool.Close()
+
+	waitFor(t, "the upstream to turn unhealthy", func() bool { return !pool.Status()[0].Healthy })
+
+	if _, err := pool.Pick(&gopherreq.HttpRequest{}); !errors.Is(err, ErrNoUpstream) {
+		t.Fatalf("Pick = %v, want ErrNoUpstream", err)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	pool, err := NewPool(PoolConfig{
+		Upstreams:   []UpstreamConfig{{URL: "http://a.test:8080", Weight: 2}, {URL: "http://b.test"}},
+		MaxFailures: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	a, b := pool.Upstreams()[0], pool.Upstreams()[1]
+	a.active.Add(3)
+	pool.report(a, true)
+	pool.report(b, true)
+	pool.report(b, true)
+
+	w := &recorder{headers: gopherreq.Headers{}}
+	pool.StatusHandler().ServeHttp(w, &gopherreq.HttpRequest{Method: common.Get})
+
+	if contentType := w.headers.Get("Content-Type").String(); contentType != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", contentType)
+	}
+	if cacheControl := w.headers.Get("Cache-Control").String(); cacheControl != "no-store" {
+		t.Fatalf("Cache-Control = %q, want no-store", cacheControl)
+	}
+
+	var status struct {
+		Upstreams []json.RawMessage `json:"upstreams"`
+	}
+	if err := json.Unmarshal(w.body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.body.String(), err)
+	}
+
+	if len(status.Upstreams) != 2 {
+		t.Fatalf("got %d upstreams in %s, want 2", len(status.Upstreams), w.body.String())
+	}
+
+	want := `{"url":"http://a.test:8080","weight":2,"available":true,"healthy":true,"ejected":false,"active_requests":3,"consecutive_failures":1}`
+	if got := string(status.Upstreams[0]); got != want {
+		t.Fatalf("status = %s, want %s", got, want)
+	}
+
+	var ejected map[string]any
+	if err := json.Unmarshal(status.Upstreams[1], &ejected); err != nil {
+		t.Fatalf("invalid JSON %s: %v", status.Upstreams[1], err)
+	}
+
+	if ejected["available"] != false || ejected["ejected"] != true || ejected["consecutive_failures"] != 0.0 {
+		t.Fatalf("status = %s, want ejected", status.Upstreams[1])
+	}
+
+	rawUntil, _ := ejected["ejected_until"].(string)
+	until, err := time.Parse(time.RFC3339Nano, rawUntil)
+	if err != nil || !until.After(time.Now()) {
+		t.Fatalf("ejected_until = %v, want a time in the future", ejected["ejected_until"])
+	}
+}
@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time for an upstream to send the headers of its response when the config does not set it.
+const DEFAULT_UPSTREAM_TIMEOUT = 30 * time.Second
+
+/*
+The hop-by-hop headers only concern a single connection so they are never forwarded. The headers listed in Connection are
+hop-by-hop as well. Proxy-Connection is not standard but still sent by some old clients.
+Ref - https://www.rfc-editor.org/rfc/rfc9110#section-7.6.1
+*/
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"TE",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type Config struct {
+	Upstreams    []string             // The base URIs of the upstreams like http://10.0.0.1:8080/api. The requests go to each of them in turn. Either it or Pool is required.
+	Pool         *Pool                // Balances the requests over its upstreams and watches their health. Upstreams is ignored when it is set.
+	Transport    *gopherreq.Transport // Opens and pools the connections to the upstreams. Defaults to gopherreq.DefaultTransport.
+	Timeout      time.Duration        // Time for the upstream to send the headers of its response. Defaults to DEFAULT_UPSTREAM_TIMEOUT. A 504 is sent when it passes.
+	PreserveHost bool                 // Sends the Host of the client to the upstream instead of the host of the upstream.
+	ErrorLog     *slog.Logger         // Receives the failures of the upstreams. Defaults to the logger of the request.
+}
+
+/*
+ReverseProxy is a Handler which forwards the requests to the upstreams and sends their responses back to the clients.
+
+The bodies are streamed in both directions without being buffered. The hop-by-hop headers are removed and the address of the
+client is added to the X-Forwarded-For and Forwarded headers. When the upstream can not be reached or sends a broken response
+the client gets 502 Bad Gateway, and 504 Gateway Timeout when it does not answer in time. Those failures count against the
+upstream in its Pool, as do the responses with one of its FailureStatuses, which are still passed on to the client. When no
+upstream is available the client gets 503 Service Unavailable.
+*/
+type ReverseProxy struct {
+	pool         *Pool
+	client       *gopherreq.Client
+	preserveHost bool
+	errorLog     *slog.Logger
+}
+
+var _ gopherreq.Handler = (*ReverseProxy)(nil)
+
+func NewReverseProxy(cfg Config) (*ReverseProxy, error) {
+	p := &ReverseProxy{
+		pool:         cfg.Pool,
+		preserveHost: cfg.PreserveHost,
+		errorLog:     cfg.ErrorLog,
+	}
+
+	if p.pool == nil {
+		poolCfg := PoolConfig{}
+		for _, rawUpstream := range cfg.Upstreams {
+			poolCfg.Upstreams = append(poolCfg.Upstreams, UpstreamConfig{URL: rawUpstream})
+		}
+
+		pool, err := NewPool(poolCfg)
+		if err != nil {
+			return nil, err
+		}
+		p.pool = pool
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_UPSTREAM_TIMEOUT
+	}
+
+	// The redirects and the failures are for the client to handle, the proxy only passes them on. No User-Agent or
+	// Content-Length is made up for a request whose client did not send one either.
+	p.client = &gopherreq.Client{
+		Transport:        cfg.Transport,
+		AttemptTimeout:   timeout,
+		MaxRedirects:     -1,
+		MaxRetries:       -1,
+		NoDefaultHeaders: true,
+	}
+
+	return p, nil
+}
+
+func (p *ReverseProxy) ServeHttp(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+	upstream, err := p.pool.Pick(req)
+	if err != nil {
+		p.logger(req).Error("no upstream to serve the request", "error", err)
+		gopherreq.Error(w, gopherreq.SERVICE_UNAVAILABLE)
+		return
+	}
+
+	upstream.active.Add(1)
+	defer upstream.active.Add(-1)
+
+	outReq := p.upstreamRequest(req, upstream.URL)
+
+	resp, err := p.client.Do(*outReq)
+	if err != nil {
+		p.reportFailure(req, upstream)
+		p.logger(req).Error("upstream request failed", "upstream", upstream.URL.Host, "error", err)
+		gopherreq.Error(w, errorStatus(err))
+		return
+	}
+	defer resp.Close()
+
+	// An upstream answering with a gateway error is as broken as one which does not answer.
+	p.pool.report(upstream, p.pool.isFailureStatus(resp.StatusCode))
+
+	// The connection headers of the server are its own, only the end-to-end headers of the upstream are added.
+	removeHopByHopHeaders(resp.Headers)
+
+	headers := w.Header()
+	for key, values := range resp.Headers {
+		headers[key] = append(headers[key], values...)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	for {
+		data, err := resp.Body.Read()
+		if len(data) != 0 {
+			_, writeErr := w.Write(data)
+			if writeErr == nil {
+				writeErr = w.Flush()
+			}
+
+			// The client went away, the rest of the body is of no use.
+			if writeErr != nil {
+				return
+			}
+		}
+
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			// The status is already sent, so the only way to tell the client the body is incomplete is to drop the connection.
+			p.reportFailure(req, upstream)
+			p.logger(req).Error("upstream response body failed", "upstream", upstream.URL.Host, "error", err)
+			panic(httperr.ErrAbortHandler)
+		}
+	}
+}
+
+// Builds the request sent to the upstream. The path of the request is appended to the path of the upstream.
+func (p *ReverseProxy) upstreamRequest(req *gopherreq.HttpRequest, upstream *url.URL) *gopherreq.HttpRequest {
+	target := *upstream
+	target.Path = joinPath(upstream.Path, req.URI.Path)
+	target.RawPath = ""
+	target.RawQuery = joinQuery(upstream.RawQuery, req.URI.RawQuery)
+	target.Fragment = ""
+
+	headers := make(gopherreq.Headers, len(req.Headers))
+	for key, values := range req.Headers {
+		headers[key] = append([]gopherreq.HeaderValue{}, values...)
+	}
+	removeHopByHopHeaders(headers)
+
+	host := req.Headers.Get("Host").String()
+	if host == "" {
+		host = req.URI.Host
+	}
+
+	if !p.preserveHost {
+		headers.Remove("Host")
+	}
+
+	addForwardedHeaders(headers, req, host)
+
+	outReq := &gopherreq.HttpRequest{
+		Method:  req.Method,
+		URI:     target,
+		Headers: headers,
+		Body:    req.Body,
+	}
+
+	return outReq.WithContext(req.Context())
+}
+
+// Removes the hop-by-hop headers along with the ones listed in Connection.
+func removeHopByHopHeaders(headers gopherreq.Headers) {
+	for _, value := range headers.GetAllValues("Connection") {
+		for _, name := range strings.Split(value.String(), ",") {
+			name = strings.Trim(name, " \t")
+			if name != "" {
+				headers.Remove(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		headers.Remove(name)
+	}
+}
+
+/*
+Adds the client to the forwarding headers. The addresses are appended to the ones added by the proxies in front.
+
+	X-Forwarded-For: 203.0.113.7, 10.0.0.2
+	X-Forwarded-Host: example.com
+	X-Forwarded-Proto: https
+	Forwarded: for=203.0.113.7;host=example.com;proto=https, for="[2001:db8::1]";host=example.com;proto=https
+
+Ref - https://www.rfc-editor.org/rfc/rfc7239
+*/
+func addForwardedHeaders(headers gopherreq.Headers, req *gopherreq.HttpRequest, host string) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = ""
+	}
+
+	if clientIP != "" {
+		forwardedFor := clientIP
+		if prior := headers.GetAllValues("X-Forwarded-For"); len(prior) != 0 {
+			forwardedFor = joinValues(prior) + ", " + clientIP
+		}
+		headers.Set("X-Forwarded-For", gopherreq.HeaderValue(forwardedFor))
+	}
+
+	if host != "" {
+		headers.Set("X-Forwarded-Host", gopherreq.HeaderValue(host))
+	}
+	headers.Set("X-Forwarded-Proto", gopherreq.HeaderValue(proto))
+
+	// Unix sockets and unknown clients are sent as "unknown" as the RFC asks.
+	node := "unknown"
+	if clientIP != "" {
+		node = clientIP
+		if strings.Contains(clientIP, ":") {
+			node = `"[` + clientIP + `]"`
+		}
+	}
+
+	element := "for=" + node
+	if host != "" {
+		element += ";host=" + quoteForwardedValue(host)
+	}
+	element += ";proto=" + proto
+
+	if prior := headers.GetAllValues("Forwarded"); len(prior) != 0 {
+		element = joinValues(prior) + ", " + element
+	}
+	headers.Set("Forwarded", gopherreq.HeaderValue(element))
+}
+
+// The values of the Forwarded parameters are tokens, anything else like a host with a port is quoted.
+func quoteForwardedValue(value string) string {
+	if strings.ContainsAny(value, ":[]\" ") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+
+	return value
+}
+
+func joinValues(values []gopherreq.HeaderValue) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, value.String())
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func joinPath(base string, path string) string {
+	if path == "" {
+		path = "/"
+	}
+
+	if base == "" || base == "/" {
+		return path
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+func joinQuery(base string, query string) string {
+	if base == "" || query == "" {
+		return base + query
+	}
+
+	return base + "&" + query
+}
+
+// An upstream which did not answer in time is reported as 504, every other failure as 502.
+func errorStatus(err error) common.StatusCode {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return gopherreq.GATEWAY_TIMEOUT
+	}
+
+	return gopherreq.BAD_GATEWAY
+}
+
+// Counts the failure against the upstream, unless the request was cancelled on the side of the client.
+func (p *ReverseProxy) reportFailure(req *gopherreq.HttpRequest, upstream *Upstream) {
+	if req.Context().Err() != nil {
+		return
+	}
+
+	p.pool.report(upstream, true)
+}
+
+func (p *ReverseProxy) logger(req *gopherreq.HttpRequest) *slog.Logger {
+	if p.errorLog != nil {
+		return p.errorLog
+	}
+
+	return req.Logger()
+}
@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	headers := gopherreq.Headers{}
+	headers.Set("Connection", "keep-alive, X-Secret")
+	headers.Apsert("Connection", " x-other ,")
+	headers.Set("Keep-Alive", "timeout=5")
+	headers.Set("Proxy-Connection", "keep-alive")
+	headers.Set("TE", "trailers")
+	headers.Set("Transfer-Encoding", "chunked")
+	headers.Set("Upgrade", "websocket")
+	headers.Set("X-Secret", "1")
+	headers.Set("X-Other", "2")
+	headers.Set("X-Kept", "3")
+	headers.Set("Content-Type", "text/plain")
+
+	removeHopByHopHeaders(headers)
+
+	if got := slices.Sorted(maps.Keys(headers)); !slices.Equal(got, []string{"Content-Type", "X-Kept"}) {
+		t.Fatalf("headers left = %q, want Content-Type and X-Kept", got)
+	}
+}
+
+func TestAddForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		tls           bool
+		host          string
+		prior         map[string][]gopherreq.HeaderValue
+		forwardedFor  string
+		forwardedHost string
+		proto         string
+		forwarded     string
+	}{
+		{
+			name:          "first proxy",
+			remoteAddr:    "203.0.113.7:51000",
+			host:          "example.com",
+			forwardedFor:  "203.0.113.7",
+			forwardedHost: "example.com",
+			proto:         "http",
+			forwarded:     "for=203.0.113.7;host=example.com;proto=http",
+		},
+		{
+			name:       "appended to the proxies in front",
+			remoteAddr: "10.0.0.2:51000",
+			tls:        true,
+			host:       "example.com",
+			prior: map[string][]gopherreq.HeaderValue{
+				"X-Forwarded-For": {"203.0.113.7, 198.51.100.1", "192.0.2.4"},
+				"Forwarded":       {"for=203.0.113.7;proto=https"},
+			},
+			forwardedFor:  "203.0.113.7, 198.51.100.1, 192.0.2.4, 10.0.0.2",
+			forwardedHost: "example.com",
+			proto:         "https",
+			forwarded:     "for=203.0.113.7;proto=https, for=10.0.0.2;host=example.com;proto=https",
+		},
+		{
+			name:          "IPv6 client and host with a port",
+			remoteAddr:    "[2001:db8::1]:51000",
+			host:          "example.com:8080",
+			forwardedFor:  "2001:db8::1",
+			forwardedHost: "example.com:8080",
+			proto:         "http",
+			forwarded:     `for="[2001:db8::1]";host="example.com:8080";proto=http`,
+		},
+		{
+			name:          "IPv6 host",
+			remoteAddr:    "203.0.113.7:51000",
+			host:          "[2001:db8::2]:8443",
+			forwardedFor:  "203.0.113.7",
+			forwardedHost: "[2001:db8::2]:8443",
+			proto:         "http",
+			forwarded:     `for=203.0.113.7;host="[2001:db8::2]:8443";proto=http`,
+		},
+		{
+			name:      "unknown client without host",
+			proto:     "http",
+			forwarded: "for=unknown;proto=http",
+		},
+		{
+			name:       "unknown client keeps the prior addresses",
+			remoteAddr: "@",
+			prior: map[string][]gopherreq.HeaderValue{
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			forwardedFor: "203.0.113.7",
+			proto:        "http",
+			forwarded:    "for=unknown;proto=http",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := gopherreq.Headers{}
+			for key, values := range test.prior {
+				headers[key] = values
+			}
+
+			req := &gopherreq.HttpRequest{RemoteAddr: test.remoteAddr}
+			if test.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+
+			addForwardedHeaders(headers, req, test.host)
+
+			for key, want := range map[string]string{
+				"X-Forwarded-For":   test.forwardedFor,
+				"X-Forwarded-Host":  test.forwardedHost,
+				"X-Forwarded-Proto": test.proto,
+				"Forwarded":         test.forwarded,
+			} {
+				if values := headers.GetAllValues(key); len(values) > 1 || headers.Get(key).String() != want {
+					t.Fatalf("%s = %q, want %q", key, values, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		base string
+		path string
+		want string
+	}{
+		{"", "/users", "/users"},
+		{"/", "/users", "/users"},
+		{"", "", "/"},
+		{"/api", "/users", "/api/users"},
+		{"/api/", "/users", "/api/users"},
+		{"/api", "", "/api/"},
+		{"/api", "/", "/api/"},
+		{"/api/v1/", "/users/", "/api/v1/users/"},
+	}
+
+	for _, test := range tests {
+		if got := joinPath(test.base, test.path); got != test.want {
+			t.Errorf("joinPath(%q, %q) = %q, want %q", test.base, test.path, got, test.want)
+		}
+	}
+}
+
+func TestJoinQuery(t *testing.T) {
+	tests := []struct {
+		base  string
+		query string
+		want  string
+	}{
+		{"", "", ""},
+		{"key=abc", "", "key=abc"},
+		{"", "page=2", "page=2"},
+		{"key=abc", "page=2&sort=name", "key=abc&page=2&sort=name"},
+	}
+
+	for _, test := range tests {
+		if got := joinQuery(test.base, test.query); got != test.want {
+			t.Errorf("joinQuery(%q, %q) = %q, want %q", test.base, test.query, got, test.want)
+		}
+	}
+}
+
+// Returns a proxy to the upstreams which logs nowhere.
+func newTestProxy(t *testing.T, cfg Config) *ReverseProxy {
+	t.Helper()
+
+	cfg.ErrorLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	p, err := NewReverseProxy(cfg)
+	if err != nil {
+		t.Fatalf("NewReverseProxy: %v", err)
+	}
+
+	return p
+}
+
+// Serves a GET of the target through the proxy and returns the recorded response along with the value the handler panicked with.
+func serveProxy(p *ReverseProxy, target string) (w *recorder, recovered any) {
+	uri, _ := url.Parse(target)
+	req := &gopherreq.HttpRequest{
+		Method:     common.Get,
+		URI:        *uri,
+		RawURI:     target,
+		Headers:    gopherreq.Headers{},
+		RemoteAddr: "203.0.113.7:51000",
+	}
+	req.Headers.Set("Host", "example.com")
+
+	w = &recorder{headers: gopherreq.Headers{}}
+
+	defer func() {
+		recovered = recover()
+	}()
+	p.ServeHttp(w, req)
+
+	return w, nil
+}
+
+func TestReverseProxy(t *testing.T) {
+	received := make(chan *gopherreq.HttpRequest, 1)
+	upstream := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		received <- req
+		w.Header().Set("Connection", "X-Private")
+		w.Header().Set("X-Private", "secret")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(gopherreq.CREATED)
+		w.Write([]byte("created"))
+	})
+
+	p := newTestProxy(t, Config{Upstreams: []string{upstream + "/api?key=abc"}})
+
+	uri, _ := url.Parse("/users?page=2")
+	req := &gopherreq.HttpRequest{
+		Method:     common.Get,
+		URI:        *uri,
+		Headers:    gopherreq.Headers{},
+		RemoteAddr: "203.0.113.7:51000",
+	}
+	req.Headers.Set("Host", "example.com")
+	req.Headers.Set("Connection", "X-Client-Private")
+	req.Headers.Set("X-Client-Private", "1")
+	req.Headers.Set("Upgrade", "websocket")
+	req.Headers.Set("X-Forwarded-For", "198.51.100.1")
+	req.Headers.Set("Accept", "text/plain")
+
+	w := &recorder{headers: gopherreq.Headers{}}
+	p.ServeHttp(w, req)
+
+	upstreamReq := <-received
+	if upstreamReq.RawURI != "/api/users?key=abc&page=2" {
+		t.Fatalf("upstream target = %q, want /api/users?key=abc&page=2", upstreamReq.RawURI)
+	}
+
+	upstreamHost := strings.TrimPrefix(upstream, "http://")
+	for key, want := range map[string]string{
+		"Host":              upstreamHost,
+		"Accept":            "text/plain",
+		"X-Forwarded-For":   "198.51.100.1, 203.0.113.7",
+		"X-Forwarded-Host":  "example.com",
+		"X-Forwarded-Proto": "http",
+		"Forwarded":         "for=203.0.113.7;host=example.com;proto=http",
+		"X-Client-Private":  "",
+		"Upgrade":           "",
+		"User-Agent":        "",
+	} {
+		if got := upstreamReq.Headers.Get(key).String(); got != want {
+			t.Fatalf("upstream %s = %q, want %q", key, got, want)
+		}
+	}
+
+	if w.code != gopherreq.CREATED || w.body.String() != "created" {
+		t.Fatalf("response = %d %q, want 201 created", w.code, w.body.String())
+	}
+
+	for key, want := range map[string]string{"X-Upstream": "1", "Connection": "", "X-Private": "", "Keep-Alive": ""} {
+		if got := w.headers.Get(key).String(); got != want {
+			t.Fatalf("response %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestReverseProxyPreserveHost(t *testing.T) {
+	received := make(chan string, 1)
+	upstream := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		received <- req.Headers.Get("Host").String()
+	})
+
+	p := newTestProxy(t, Config{Upstreams: []string{upstream}, PreserveHost: true})
+	serveProxy(p, "/")
+
+	if host := <-received; host != "example.com" {
+		t.Fatalf("upstream Host = %q, want the Host of the client", host)
+	}
+}
+
+func TestReverseProxyUpstreamFailures(t *testing.T) {
+	// An address nothing listens on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	slow := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	})
+
+	broken := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.Flush()
+		panic(httperr.ErrAbortHandler)
+	})
+
+	unavailable := startUpstream(t, func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		gopherreq.Error(w, gopherreq.SERVICE_UNAVAILABLE)
+	})
+
+	tests := []struct {
+		name      string
+		upstream  string
+		status    common.StatusCode
+		body      string
+		recovered any
+	}{
+		{"dial failure", closed, gopherreq.BAD_GATEWAY, "Bad Gateway", nil},
+		{"timeout", slow, gopherreq.GATEWAY_TIMEOUT, "Gateway Timeout", nil},
+		{"body failing midway", broken, gopherreq.OK, "partial", httperr.ErrAbortHandler},
+		{"failure status passed on", unavailable, gopherreq.SERVICE_UNAVAILABLE, "Service Unavailable", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool, err := NewPool(PoolConfig{Upstreams: []UpstreamConfig{{URL: test.upstream}}})
+			if err != nil {
+				t.Fatalf("NewPool: %v", err)
+			}
+
+			p := newTestProxy(t, Config{Pool: pool, Timeout: 100 * time.Millisecond})
+
+			w, recovered := serveProxy(p, "/")
+
+			if recovered != test.recovered {
+				t.Fatalf("handler panicked with %v, want %v", recovered, test.recovered)
+			}
+			if w.code != test.status || w.body.String() != test.body {
+				t.Fatalf("response = %d %q, want %d %q", w.code, w.body.String(), test.status, test.body)
+			}
+
+			if failures := pool.Status()[0].ConsecutiveFailures; failures != 1 {
+				t.Fatalf("consecutive failures = %d, want the failure counted", failures)
+			}
+			if active := pool.Upstreams()[0].ActiveRequests(); active != 0 {
+				t.Fatalf("active requests = %d after the response, want 0", active)
+			}
+		})
+	}
+}
+
+func TestReverseProxyNoUpstream(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Upstreams: []UpstreamConfig{{URL: "http://a.test"}}, MaxFailures: 1})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	pool.report(pool.Upstreams()[0], true)
+
+	w, _ := serveProxy(newTestProxy(t, Config{Pool: pool}), "/")
+
+	if w.code != gopherreq.SERVICE_UNAVAILABLE {
+		t.Fatalf("status = %d, want 503", w.code)
+	}
+}
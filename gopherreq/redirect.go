@@ -0,0 +1,154 @@
+package gopherreq
+
+import (
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/cookie"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Redirects followed for a request when the client does not set it.
+const DEFAULT_MAX_REDIRECTS = 10
+
+/*
+Builds the request which follows the redirect response. It reports false when the response is not a redirect the client can
+follow, in which case it is returned to the caller as is.
+
+	301, 302 : A POST becomes a GET without body, like the browsers do. The other methods are kept.
+	303      : Every method but HEAD becomes a GET without body.
+	307, 308 : The method and the body are kept. The body must be rewound so it can be sent again.
+
+The credentials of the request are only sent to the origin they were meant for. When the redirect leads to another scheme,
+host or port, the Authorization and Cookie headers and the cookies of the request are dropped.
+Ref - https://www.rfc-editor.org/rfc/rfc9110#section-15.4
+*/
+func redirectRequest(resp *HttpResponse, rewind func() error) (next HttpRequest, follow bool, err error) {
+	req := resp.Request
+
+	switch resp.StatusCode {
+	case MOVED_PERMANENTLY, FOUND, SEE_OTHER, TEMPORARY_REDIRECT, PERMANENT_REDIRECT:
+	default:
+		return
+	}
+
+	rawLocation := resp.Headers.Get("Location").String()
+	if rawLocation == "" {
+		return
+	}
+
+	location, parseErr := url.Parse(rawLocation)
+	if parseErr != nil {
+		return
+	}
+
+	target := req.URI.ResolveReference(location)
+	target.Scheme = strings.ToLower(target.Scheme)
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return
+	}
+
+	// The fragment of the original URI is kept when the location has none. Ref - https://www.rfc-editor.org/rfc/rfc9110#section-10.2.2
+	if target.Fragment == "" {
+		target.Fragment = req.URI.Fragment
+	}
+
+	next = *req
+	next.URI = *target
+	next.Headers = make(Headers, len(req.Headers))
+	for key, values := range req.Headers {
+		next.Headers[key] = slices.Clone(values)
+	}
+
+	// The Host belongs to the previous URI, it is set again from the new one.
+	next.Headers.Remove("Host")
+
+	dropBody := false
+	switch resp.StatusCode {
+	case MOVED_PERMANENTLY, FOUND:
+		dropBody = req.Method == common.Post
+	case SEE_OTHER:
+		dropBody = req.Method != common.Head
+	}
+
+	if dropBody {
+		next.Method = common.Get
+		next.Body = NoBody
+		next.Headers.Remove("Content-Length")
+		next.Headers.Remove("Transfer-Encoding")
+		next.Headers.Remove("Content-Type")
+	} else if next.Body != NoBody {
+		if rewind == nil {
+			return HttpRequest{}, false, nil
+		}
+
+		err = rewind()
+		if err != nil {
+			return HttpRequest{}, false, err
+		}
+	}
+
+	if !sameOrigin(&req.URI, target) {
+		next.Headers.Remove("Authorization")
+		next.Headers.Remove("Cookie")
+		next.Cookies = cookie.NewCookieList()
+	}
+
+	return next, true, nil
+}
+
+func (c *Client) maxRedirects() int {
+	if c.MaxRedirects == 0 {
+		return DEFAULT_MAX_REDIRECTS
+	}
+
+	return c.MaxRedirects
+}
+
+// Reports if both URIs have the same scheme, host and port.
+func sameOrigin(a *url.URL, b *url.URL) bool {
+	if a.Scheme != b.Scheme {
+		return false
+	}
+
+	return strings.EqualFold(hostWithPort(a.Scheme, a.Host), hostWithPort(b.Scheme, b.Host))
+}
+
+// Returns a function which moves the body back to where it started so it can be sent again. It is nil when the body can not be rewound.
+func bodyRewinder(body RequestBody) func() error {
+	if body == NoBody {
+		return func() error { return nil }
+	}
+
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return nil
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+
+	return func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}
+}
+
+// Reads what is left of a response which is not returned to the caller so its connection can be reused. Long bodies close it instead.
+func discardResponse(resp *HttpResponse) {
+	discarded := int64(0)
+
+	for discarded <= MAX_DISCARD_BODY_BYTES {
+		data, err := resp.Body.Read()
+		discarded += int64(len(data))
+
+		if err != nil {
+			break
+		}
+	}
+
+	resp.Close()
+}
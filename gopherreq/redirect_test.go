@@ -0,0 +1,263 @@
+package gopherreq
+
+import (
+	"bytes"
+	"errors"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/cookie"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Returns the response of the status and Location to the request, as read by the client.
+func redirectResponse(req *HttpRequest, status common.StatusCode, location string) *HttpResponse {
+	resp := &HttpResponse{StatusCode: status, Headers: Headers{}, Request: req}
+	if location != "" {
+		resp.Headers.Set("Location", HeaderValue(location))
+	}
+
+	return resp
+}
+
+func TestRedirectMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   common.HttpMethod
+		status   common.StatusCode
+		follow   bool
+		want     common.HttpMethod
+		keepBody bool
+	}{
+		{"301 POST", common.Post, MOVED_PERMANENTLY, true, common.Get, false},
+		{"301 PUT", common.Put, MOVED_PERMANENTLY, true, common.Put, true},
+		{"302 POST", common.Post, FOUND, true, common.Get, false},
+		{"302 DELETE", common.Delete, FOUND, true, common.Delete, true},
+		{"303 PUT", common.Put, SEE_OTHER, true, common.Get, false},
+		{"303 POST", common.Post, SEE_OTHER, true, common.Get, false},
+		{"303 HEAD", common.Head, SEE_OTHER, true, common.Head, true},
+		{"307 POST", common.Post, TEMPORARY_REDIRECT, true, common.Post, true},
+		{"308 PUT", common.Put, PERMANENT_REDIRECT, true, common.Put, true},
+		{"300 not followed", common.Get, MULTIPLE_CHOICES, false, "", false},
+		{"304 not followed", common.Get, NOT_MODIFIED, false, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := bytes.NewReader([]byte("payload"))
+			req := &HttpRequest{
+				Method:  test.method,
+				URI:     url.URL{Scheme: "http", Host: "a.test", Path: "/old"},
+				Headers: Headers{},
+				Body:    body,
+			}
+			req.Headers.Set("Content-Type", "text/plain")
+			req.Headers.Set("Content-Length", "7")
+
+			rewind := bodyRewinder(body)
+
+			// The body was sent with the first request.
+			io.ReadAll(body)
+
+			next, follow, err := redirectRequest(redirectResponse(req, test.status, "/new"), rewind)
+			if err != nil {
+				t.Fatalf("redirectRequest: %v", err)
+			}
+			if follow != test.follow {
+				t.Fatalf("follow = %v, want %v", follow, test.follow)
+			}
+			if !follow {
+				return
+			}
+
+			if next.Method != test.want {
+				t.Fatalf("method = %s, want %s", next.Method, test.want)
+			}
+			if next.URI.String() != "http://a.test/new" {
+				t.Fatalf("URI = %s, want http://a.test/new", next.URI.String())
+			}
+
+			if !test.keepBody {
+				if next.Body != NoBody {
+					t.Fatal("the body was kept")
+				}
+				for _, key := range []string{"Content-Type", "Content-Length"} {
+					if value := next.Headers.Get(key); value != "" {
+						t.Fatalf("%s = %q kept without the body", key, value)
+					}
+				}
+				return
+			}
+
+			// The body is sent again from its start.
+			data, _ := io.ReadAll(next.Body)
+			if string(data) != "payload" {
+				t.Fatalf("body = %q, want it rewound", data)
+			}
+			if value := next.Headers.Get("Content-Length"); value != "7" {
+				t.Fatalf("Content-Length = %q, want 7", value)
+			}
+		})
+	}
+}
+
+// A body which can not be sent again stops at a redirect which keeps it.
+func TestRedirectUnrewindableBody(t *testing.T) {
+	req := &HttpRequest{Method: common.Post, URI: url.URL{Scheme: "http", Host: "a.test"}, Headers: Headers{}, Body: strings.NewReader("x")}
+
+	if _, follow, err := redirectRequest(redirectResponse(req, TEMPORARY_REDIRECT, "/new"), nil); follow || err != nil {
+		t.Fatalf("307 = %v, %v, want the response returned as is", follow, err)
+	}
+
+	if next, follow, err := redirectRequest(redirectResponse(req, SEE_OTHER, "/new"), nil); !follow || err != nil || next.Body != NoBody {
+		t.Fatalf("303 = %v, %v, want a GET without body", follow, err)
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		follow   bool
+		want     string
+	}{
+		{"/new?q=1", true, "http://a.test/new?q=1#part"},
+		{"new", true, "http://a.test/dir/new#part"},
+		{"//b.test/other", true, "http://b.test/other#part"},
+		{"https://b.test/other#top", true, "https://b.test/other#top"},
+		{"HTTPS://b.test/", true, "https://b.test/#part"},
+		{"", false, ""},
+		{"ftp://b.test/file", false, ""},
+		{"http:///no-host", false, ""},
+		{"http://[::1", false, ""},
+	}
+
+	for _, test := range tests {
+		req := &HttpRequest{Method: common.Get, URI: url.URL{Scheme: "http", Host: "a.test", Path: "/dir/old", Fragment: "part"}, Headers: Headers{}, Body: NoBody}
+
+		next, follow, err := redirectRequest(redirectResponse(req, FOUND, test.location), bodyRewinder(NoBody))
+		if err != nil || follow != test.follow {
+			t.Fatalf("Location %q: follow = %v, %v, want %v", test.location, follow, err, test.follow)
+		}
+		if follow && next.URI.String() != test.want {
+			t.Fatalf("Location %q: URI = %s, want %s", test.location, next.URI.String(), test.want)
+		}
+	}
+}
+
+// The credentials only follow a redirect to the same scheme, host and port.
+func TestRedirectSensitiveHeaders(t *testing.T) {
+	tests := []struct {
+		location string
+		kept     bool
+	}{
+		{"http://a.test/new", true},
+		{"http://A.TEST:80/new", true},
+		{"/new", true},
+		{"http://b.test/new", false},
+		{"http://a.test:8080/new", false},
+		{"https://a.test/new", false},
+		{"http://sub.a.test/new", false},
+	}
+
+	for _, test := range tests {
+		req := &HttpRequest{
+			Method:  common.Get,
+			URI:     url.URL{Scheme: "http", Host: "a.test", Path: "/old"},
+			Headers: Headers{},
+			Cookies: cookie.NewCookieList(),
+			Body:    NoBody,
+		}
+		req.Headers.Set("Host", "a.test")
+		req.Headers.Set("Authorization", "Bearer secret")
+		req.Headers.Set("Cookie", "id=1")
+		req.Headers.Set("Accept", "text/html")
+		req.Cookies.Add(cookie.Cookie{Name: "id", Value: "1"})
+
+		next, follow, err := redirectRequest(redirectResponse(req, FOUND, test.location), bodyRewinder(NoBody))
+		if err != nil || !follow {
+			t.Fatalf("%s: follow = %v, %v", test.location, follow, err)
+		}
+
+		_, hasCookie := next.Cookies.Get("id")
+		for name, kept := range map[string]bool{
+			"Authorization": next.Headers.Get("Authorization") != "",
+			"Cookie":        next.Headers.Get("Cookie") != "",
+			"cookie list":   hasCookie,
+		} {
+			if kept != test.kept {
+				t.Fatalf("%s: %s kept = %v, want %v", test.location, name, kept, test.kept)
+			}
+		}
+
+		if next.Headers.Get("Accept") != "text/html" {
+			t.Fatalf("%s: Accept was dropped", test.location)
+		}
+		if host := next.Headers.Get("Host"); host != "" {
+			t.Fatalf("%s: Host %q of the previous URI kept", test.location, host)
+		}
+
+		// The request which was redirected is left untouched.
+		if req.Headers.Get("Authorization") == "" || !req.Cookies.Exists("id") {
+			t.Fatalf("%s: the original request was changed", test.location)
+		}
+	}
+}
+
+func TestClientMaxRedirects(t *testing.T) {
+	var hits atomic.Int32
+	server := startTestServer(t, Config{
+		Handler: HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+			hits.Add(1)
+
+			hop, _ := strconv.Atoi(strings.TrimPrefix(req.URI.Path, "/"))
+			if hop == 5 {
+				w.Write([]byte("done"))
+				return
+			}
+
+			w.Header().Set("Location", HeaderValue("/"+strconv.Itoa(hop+1)))
+			w.WriteHeader(FOUND)
+		}),
+	})
+	address := server.Addrs()[0].String()
+
+	tests := []struct {
+		name         string
+		maxRedirects int
+		status       common.StatusCode
+		err          error
+		hits         int32
+	}{
+		{"followed to the end", 0, OK, nil, 6},
+		{"exactly enough", 5, OK, nil, 6},
+		{"limit reached", 3, 0, httperr.ErrTooManyRedirects, 4},
+		{"not followed", -1, FOUND, nil, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hits.Store(0)
+			client := &Client{Transport: &Transport{}, MaxRedirects: test.maxRedirects}
+
+			resp, err := client.Do(HttpRequest{URI: url.URL{Scheme: "http", Host: address, Path: "/0"}})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Do error = %v, want %v", err, test.err)
+			}
+
+			if err == nil {
+				resp.ReadAll()
+				if resp.StatusCode != test.status {
+					t.Fatalf("status = %d, want %d", resp.StatusCode, test.status)
+				}
+			}
+
+			if count := hits.Load(); count != test.hits {
+				t.Fatalf("server got %d requests, want %d", count, test.hits)
+			}
+		})
+	}
+}
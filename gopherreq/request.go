@@ -1,34 +1,96 @@
 package gopherreq
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"gopherreq/gopherreq/common"
 	"gopherreq/gopherreq/cookie"
 	"gopherreq/gopherreq/httperr"
 
 	"io"
+	"log/slog"
 	"net"
 	"net/url"
-	"slices"
+	"regexp"
 	"strconv"
 	"strings"
-	"time"
-	"unicode"
 )
 
 type HttpRequest struct {
-	Headers Headers           // The headers received from the client.
-	Cookies cookie.CookieList // Stores the cookies received by the client in parsed format. These are cleaned and stored.
-	Body    RequestBody       // The request body received from the client.
-	Method  common.HttpMethod // The HTTP method for the request.
-	URI     url.URL           // The URI for the request. It is parsed and clean version. You can read the query variables from here.
-	Version string            // The HTTP Version for the request.
-	RawURI  string            // The raw unformatted version of the uri as received from the client. Always use URI wherever possible instead of this.It is not sanitized and may lead to attacks.
+	Headers    Headers              // The headers received from the client.
+	Cookies    cookie.CookieList    // Stores the cookies received by the client in parsed format. These are cleaned and stored.
+	Body       RequestBody          // The request body received from the client.
+	Method     common.HttpMethod    // The HTTP method for the request.
+	URI        url.URL              // The URI for the request. It is parsed and clean version. You can read the query variables from here.
+	Version    string               // The HTTP Version for the request as received. Eg. HTTP/1.1
+	ProtoMajor int                  // The major version of the protocol. It is always 1.
+	ProtoMinor int                  // The minor version of the protocol. Versions above 1.1 are served as 1.1.
+	RawURI     string               // The raw unformatted version of the uri as received from the client. Always use URI wherever possible instead of this.It is not sanitized and may lead to attacks.
+	TargetForm RequestTargetForm    // The form of the request target. Proxies receive the absolute form and CONNECT the authority form.
+	Params     map[string]string    // The path parameters captured by the router for the matched route.
+	Trailers   Headers              // The trailer fields sent after a chunked body. They are only available once the body is read completely.
+	RemoteAddr string               // The network address of the client which sent the request.
+	TLS        *tls.ConnectionState // The state of the TLS connection the request came on. It is nil for plain connections.
+	RequestID  string               // The ID of the request. It is set by the RequestID middleware.
+
+	ctx    context.Context
+	logger *slog.Logger
 }
 
 type RequestBody io.Reader
 
+// HTTP-version = HTTP-name "/" DIGIT "." DIGIT
+var httpVersionFormat = regexp.MustCompile(`^HTTP/[0-9]\.[0-9]$`)
+
+// Returns the context of the request. It is cancelled when the handler returns or when the server is forcefully shut down.
+func (req *HttpRequest) Context() context.Context {
+	if req.ctx == nil {
+		return context.Background()
+	}
+
+	return req.ctx
+}
+
+// Returns the error logger of the server with the details of the request attached. Handlers can use it to report their errors.
+func (req *HttpRequest) Logger() *slog.Logger {
+	logger := req.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	logger = logger.With("method", req.Method, "uri", req.RawURI, "remote_addr", req.RemoteAddr)
+	if req.RequestID != "" {
+		logger = logger.With("request_id", req.RequestID)
+	}
+
+	return logger
+}
+
+// Returns a shallow copy of the request with its context changed to ctx.
+func (req *HttpRequest) WithContext(ctx context.Context) *HttpRequest {
+	if ctx == nil {
+		panic("gopherreq: nil context")
+	}
+
+	clone := *req
+	clone.ctx = ctx
+
+	return &clone
+}
+
+// Reports if the version of the request is at least major.minor.
+func (req *HttpRequest) ProtoAtLeast(major int, minor int) bool {
+	return req.ProtoMajor > major || req.ProtoMajor == major && req.ProtoMinor >= minor
+}
+
+// Returns the value of the path parameter captured by the router. It returns an empty string if the parameter does not exist.
+func (req *HttpRequest) PathParam(name string) string {
+	return req.Params[name]
+}
+
 func parseRequestLine(rawData string) (reqLine RequestLine, err error) {
 	// Format - Method SP Request-URI SP HTTP-Version
 	// Ref - https://www.w3.org/Protocols/HTTP/1.1/draft-ietf-http-v11-spec-01#Request-Line
@@ -42,147 +104,336 @@ func parseRequestLine(rawData string) (reqLine RequestLine, err error) {
 		return
 	}
 
+	// The version is checked first since the rest of the line can only be understood for a version we speak.
+	rawVersion := indiviualData[2]
+	if !httpVersionFormat.MatchString(rawVersion) {
+		err = fmt.Errorf("%w: malformed version %q", httperr.ErrInvalidRequestLine, rawVersion)
+		return
+	}
+
+	// The format guarantees a single digit on both sides of the dot.
+	reqLine.ProtoMajor = int(rawVersion[5] - '0')
+	reqLine.ProtoMinor = int(rawVersion[7] - '0')
+
+	// A minor version is compatible with the others of its major so only the major decides if we can answer.
+	if reqLine.ProtoMajor != SUPPORTED_HTTP_MAJOR {
+		err = httperr.ErrUnsupportedHttpVersion
+		return
+	}
+
 	rawMethod := indiviualData[0]
 	rawMethod = strings.Trim(rawMethod, " ")
 
-	if !slices.Contains(supportedHttpMethods, common.HttpMethod(rawMethod)) {
+	if !IsSupportedMethod(common.HttpMethod(rawMethod)) {
 		err = httperr.ErrInvalidHttpMethod
 		return
 	}
 
 	reqLine.Method = common.HttpMethod(rawMethod)
+	reqLine.Version = rawVersion
 
-	// By default set the resource as self.
-	selfResourceUrl, err := url.Parse("*")
+	err = parseRequestTarget(&reqLine, indiviualData[1])
 
-	if err != nil {
-		return reqLine, err
-	}
+	return
+}
+
+/*
+Parses the request target into the URI according to its form.
+
+	request-target = origin-form / absolute-form / authority-form / asterisk-form
 
-	reqLine.URI = *selfResourceUrl
+The authority form is only valid for CONNECT and the asterisk form only for OPTIONS, any other combination is rejected.
+Ref - https://www.rfc-editor.org/rfc/rfc9112#section-3.2
+*/
+func parseRequestTarget(reqLine *RequestLine, target string) error {
+	reqLine.RawTarget = target
 
-	if indiviualData[1] != "*" {
-		uri, err := url.ParseRequestURI(indiviualData[1])
+	switch {
+	case reqLine.Method == common.Connect:
+		// authority-form = uri-host ":" port
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || !isValidPort(port) || !isValidHost(target) {
+			return fmt.Errorf("%w: CONNECT needs a host and a port", httperr.ErrInvalidRequestLine)
+		}
+
+		reqLine.TargetForm = AuthorityForm
+		reqLine.URI = url.URL{Host: target}
+
+	case target == "*":
+		if reqLine.Method != common.Options {
+			return fmt.Errorf("%w: the asterisk form is only allowed for OPTIONS", httperr.ErrInvalidRequestLine)
+		}
+
+		reqLine.TargetForm = AsteriskForm
+		reqLine.URI = url.URL{Path: "*"}
 
+	case strings.HasPrefix(target, "/"):
+		// origin-form = absolute-path [ "?" query ]
+		uri, err := url.ParseRequestURI(target)
 		if err != nil {
-			return reqLine, err
+			return fmt.Errorf("%w: %v", httperr.ErrInvalidRequestLine, err)
+		}
+
+		reqLine.TargetForm = OriginForm
+		reqLine.URI = *uri
+
+	default:
+		// absolute-form = absolute-URI
+		uri, err := url.ParseRequestURI(target)
+		if err != nil || uri.Scheme == "" || uri.Host == "" || uri.User != nil || !isValidHost(uri.Host) {
+			return fmt.Errorf("%w: invalid absolute target %q", httperr.ErrInvalidRequestLine, target)
+		}
+
+		uri.Scheme = strings.ToLower(uri.Scheme)
+		if uri.Path == "" {
+			uri.Path = "/"
 		}
 
+		reqLine.TargetForm = AbsoluteForm
 		reqLine.URI = *uri
 	}
 
-	reqLine.Version = indiviualData[2]
+	return nil
+}
 
-	return
+/*
+Applies the Host rules to the request once the headers are known.
+
+An HTTP/1.1 request must have exactly one Host field. When the target is in absolute form its host wins over the field, which
+is kept as received. Ref - https://www.rfc-editor.org/rfc/rfc9112#section-3.2
+*/
+func resolveHost(request *HttpRequest) error {
+	hosts := request.Headers.GetAllValues("Host")
+
+	if len(hosts) > 1 {
+		return fmt.Errorf("%w: more than one Host field", httperr.ErrInvalidHost)
+	}
+
+	if len(hosts) == 0 {
+		if request.ProtoAtLeast(1, 1) {
+			return fmt.Errorf("%w: missing Host field", httperr.ErrInvalidHost)
+		}
+		return nil
+	}
+
+	host := hosts[0].String()
+
+	// An empty Host is allowed when the target has no authority, but it must be a valid host otherwise.
+	if host != "" && !isValidHost(host) {
+		return fmt.Errorf("%w: %q", httperr.ErrInvalidHost, host)
+	}
+
+	if request.TargetForm == OriginForm || request.TargetForm == AsteriskForm {
+		request.URI.Host = host
+	}
+
+	return nil
 }
 
-func parseRequestHeaders(rawHeaders string) Headers {
+// Reports if the value is a valid uri-host with an optional port, the grammar of the Host field.
+func isValidHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, " \t/?#@\\") {
+		return false
+	}
 
-	splitHeader := strings.Split(rawHeaders, "\r\n")
+	uri, err := url.Parse("//" + host)
+	if err != nil || uri.Host != host {
+		return false
+	}
+
+	if strings.HasSuffix(host, ":") {
+		return false
+	}
+
+	if port := uri.Port(); port != "" {
+		return isValidPort(port)
+	}
+
+	return true
+}
+
+func isValidPort(port string) bool {
+	number, err := strconv.Atoi(port)
+
+	return err == nil && number >= 0 && number <= 65535 && strings.TrimLeft(port, "0123456789") == ""
+}
+
+// Controls how the header fields are parsed.
+type headerOptions struct {
+	lenient       bool // Accepts the malformed lines like the original parser did instead of rejecting them.
+	maxBytes      int  // Size of the whole section accepted, the header with its start line or the trailers. Defaults to HEADER_LIMIT_BYTES.
+	maxCount      int  // Number of fields accepted. Zero means no limit.
+	maxFieldBytes int  // Size of a single field line accepted. Zero means no limit.
+}
+
+/*
+Parses the header field lines separated by CRLF.
+
+	field-line = field-name ":" OWS field-value OWS
+
+In strict mode every line must follow the grammar of RFC 9112, otherwise the request is rejected with 400. Lines folded over
+several lines (obs-fold), whitespace between the name and the colon and control characters in the value are all refused since
+the servers and proxies in front of us may read them differently. The limits are enforced in both modes.
+Ref - https://www.rfc-editor.org/rfc/rfc9112#section-5
+*/
+func parseRequestHeaders(rawHeaders string, opts headerOptions) (Headers, error) {
 
 	headers := make(Headers)
-	for _, line := range splitHeader {
 
-		row := strings.SplitN(line, ":", 2)
+	if rawHeaders == "" {
+		return headers, nil
+	}
 
-		if len(row) != 2 {
-			continue
+	lines := strings.Split(rawHeaders, "\r\n")
+	count := 0
+
+	for index := 0; index < len(lines); index++ {
+		line := lines[index]
+
+		if opts.lenient {
+			// The folded lines are joined to the previous field with a single space.
+			for index+1 < len(lines) && len(lines[index+1]) != 0 && isWhitespace(lines[index+1][0]) {
+				index++
+				line += " " + strings.Trim(lines[index], " \t")
+			}
+		}
+
+		// A folded field is checked once joined so its continuation lines can not get around the limit.
+		if opts.maxFieldBytes > 0 && len(line) > opts.maxFieldBytes {
+			return nil, fmt.Errorf("%w: header field is longer than %d bytes", httperr.ErrHeaderLimitExceeded, opts.maxFieldBytes)
 		}
 
-		// Trim spaces from key and value
-		key := strings.Trim(row[0], " ")
-		value := strings.Trim(row[1], " ")
+		if !opts.lenient && strings.ContainsAny(line, "\r\n") {
+			return nil, fmt.Errorf("%w: bare CR or LF in header", httperr.ErrInvalidHeader)
+		}
+		if !opts.lenient && len(line) != 0 && isWhitespace(line[0]) {
+			return nil, fmt.Errorf("%w: obsolete line folding", httperr.ErrInvalidHeader)
+		}
 
-		// Split key by "-" and capitalize each part
-		parts := strings.Split(key, "-")
-		for i, part := range parts {
-			if len(part) > 0 {
-				// Capitalize first letter and keep rest as is
-				parts[i] = string(unicode.ToUpper(rune(part[0]))) + part[1:]
+		name, value, found := strings.Cut(line, ":")
+
+		if opts.lenient {
+			name = strings.Trim(name, " \t")
+			if !found || name == "" {
+				continue
+			}
+		} else {
+			if !found {
+				return nil, fmt.Errorf("%w: missing colon", httperr.ErrInvalidHeader)
 			}
+			if !isToken(name) {
+				return nil, fmt.Errorf("%w: invalid field name %q", httperr.ErrInvalidHeader, name)
+			}
+		}
+
+		value = strings.Trim(value, " \t")
+
+		if !opts.lenient && !isValidFieldValue(value) {
+			return nil, fmt.Errorf("%w: invalid characters in the value of %s", httperr.ErrInvalidHeader, name)
 		}
 
-		// Join parts back with "-"
-		finalKey := strings.Join(parts, "-")
+		count++
+		if opts.maxCount > 0 && count > opts.maxCount {
+			return nil, fmt.Errorf("%w: more than %d header fields", httperr.ErrHeaderLimitExceeded, opts.maxCount)
+		}
 
-		headers.Apsert(finalKey, HeaderValue(value))
+		headers.Apsert(name, HeaderValue(value))
 	}
-	return headers
+
+	return headers, nil
 }
 
-// Reads the header from the connection.
-func (h HttpServer) readHeader(conn net.Conn) (request HttpRequest, err error) {
+// Reads the header from the connection. The reader is shared by all the requests on the connection so bytes after the header are kept for the body and the pipelined requests.
+func (h *HttpServer) readHeader(conn net.Conn, reader *bufio.Reader) (request HttpRequest, err error) {
 
-	// Adjust the read deadline.
-	conn.SetReadDeadline(time.Now().Add(time.Duration(h.timeout) * time.Millisecond))
+	// The deadline is set once for the whole header. Extending it on every read would let a client trickling a byte at a time hold the connection forever.
+	conn.SetReadDeadline(deadlineAfter(h.readHeaderTimeout))
 
-	data := new(bytes.Buffer)
-	readBuffer := make([]byte, 1024)
+	headers, err := readHeaderSection(reader, h.headerOptions.maxBytes)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return request, httperr.ErrRequestHeaderTimeout
+		}
+		return request, err
+	}
 
-	// This loop keeps on reading headers if it does not fit in one buffer.
-	for {
-		bytesReadCount, err := conn.Read(readBuffer)
+	// A request without any header fields only has the request line.
+	reqLine, rawHeaders, _ := strings.Cut(headers, "\r\n")
 
-		// Update the read deadline.
-		conn.SetReadDeadline(time.Now().Add(time.Duration(h.timeout * int(time.Millisecond))))
+	parsedReqLine, err := parseRequestLine(reqLine)
+	if err != nil {
+		return request, err
+	}
 
-		if err != nil {
-			if err == io.EOF {
-				fmt.Println("Client closed the connection")
-				break
-			}
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				fmt.Println("Read timeout - no more data expected")
-				break
-			}
-			fmt.Printf("Error while reading from the connection: %v\n", err)
-			return request, err
-		}
+	parsedHeaders, err := parseRequestHeaders(rawHeaders, h.headerOptions)
+	if err != nil {
+		return request, err
+	}
 
-		data.Write(readBuffer[:bytesReadCount])
+	request.Headers = parsedHeaders
+	request.URI = parsedReqLine.URI
+	request.Method = parsedReqLine.Method
+	request.Version = parsedReqLine.Version
+	request.ProtoMajor = parsedReqLine.ProtoMajor
+	request.ProtoMinor = parsedReqLine.ProtoMinor
+	request.RawURI = parsedReqLine.RawTarget
+	request.TargetForm = parsedReqLine.TargetForm
 
-		if uint32(data.Len()) > HEADER_LIMIT_BYTES {
-			fmt.Printf("Header len limit: %v", data.Len())
-			err = httperr.ErrHeaderLimitExceeded
-			return request, err
-		}
+	err = resolveHost(&request)
+	if err != nil {
+		return request, err
+	}
+
+	return request, nil
+}
 
-		headerEnd := bytes.Index(data.Bytes(), []byte("\r\n\r\n"))
-		if headerEnd != -1 { // If we have found header end
-			headers := string(data.Bytes()[:headerEnd])
-			reqLineIdx := strings.Index(headers, "\r\n")
+/*
+Reads the start line and the header fields up to the empty line which ends them. It is shared by the requests read by the
+server and the responses read by the client. The returned section does not include the empty line.
 
-			reqLine := headers[:reqLineIdx]
-			parsedReqLine, err := parseRequestLine(reqLine)
-			if err != nil {
-				return request, err
+It returns io.EOF when the connection ends before any byte and httperr.ErrIncompleteHeader when it ends in the middle.
+*/
+func readHeaderSection(reader *bufio.Reader, maxBytes int) (string, error) {
+	data := new(bytes.Buffer)
+
+	// This loop reads the header line by line until the empty line which ends it.
+	for {
+		line, err := reader.ReadSlice('\n')
+
+		if err != nil && err != bufio.ErrBufferFull {
+			// The peer closed the connection before finishing the header.
+			if err == io.EOF {
+				if data.Len() == 0 {
+					return "", io.EOF
+				}
+				return "", httperr.ErrIncompleteHeader
 			}
+			return "", err
+		}
 
-			parsedHeaders := parseRequestHeaders(headers[reqLineIdx+2:]) // Added +2 to skip \r\n
+		// Empty lines received before the start line are ignored. Some clients send an extra CRLF after a body.
+		if data.Len() == 0 && (string(line) == "\r\n" || string(line) == "\n") {
+			continue
+		}
 
-			request.Headers = parsedHeaders
+		data.Write(line)
 
-			host := request.Headers.Get("host")
+		if data.Len() > maxBytes {
+			return "", httperr.ErrHeaderLimitExceeded
+		}
 
-			if host != "" {
-				parsedReqLine.URI.Host = host.String()
-				request.URI = parsedReqLine.URI
-				request.Method = parsedReqLine.Method
-				request.Version = parsedReqLine.Version
-				request.RawURI = parsedReqLine.URI.String()
-			}
-			return request, nil // Return immediately after parsing headers
+		if bytes.HasSuffix(data.Bytes(), []byte("\r\n\r\n")) {
+			// We have found the header end.
+			return string(data.Bytes()[:data.Len()-4]), nil
 		}
 	}
-
-	return request, httperr.ErrIncompleteHeader
 }
 
 func parseRequestCookie(request *HttpRequest) error {
 
-	if len(request.Headers["Cookie"]) != 0 {
+	request.Cookies = cookie.NewCookieList()
 
-		request.Cookies = cookie.NewCookieList()
+	if len(request.Headers["Cookie"]) != 0 {
 
 		cookieValues := request.Headers.GetAllValues("Cookie")
 
@@ -192,9 +443,9 @@ func parseRequestCookie(request *HttpRequest) error {
 
 			for _, splitCookie := range splitCookies {
 
+				// Invalid cookies are skipped instead of failing the whole request.
 				c, err := cookie.ParseRequestCookie(splitCookie)
 				if err != nil {
-					fmt.Printf("Error cookie is not valid - %v", err)
 					continue
 				}
 				request.Cookies.Add(c)
@@ -208,32 +459,134 @@ func parseRequestCookie(request *HttpRequest) error {
 }
 
 /**
- * This function reads the body from the request and stores in binary form.
+ * This function prepares the body of the request. The body is not read here, it is streamed from the connection when the handler reads it.
+ *
+ * The length of the body is the one thing every server and proxy on the path must agree on, otherwise the rest of the body is
+ * read as another request (request smuggling). So every request whose length could be read in two ways is refused and the
+ * connection closed. Ref - https://www.rfc-editor.org/rfc/rfc9112#section-6.3
  */
-func (req *HttpRequest) readBody(conn net.Conn) (err error) {
+func (req *HttpRequest) readBody(reader *bufio.Reader, opts headerOptions) (err error) {
+
+	transferEncodings := req.Headers.GetAllValues("Transfer-Encoding")
+	contentLengths := req.Headers.GetAllValues("Content-Length")
 
-	rawLen := "0"
+	if len(transferEncodings) != 0 {
+		if len(contentLengths) != 0 {
+			return httperr.ErrAmbiguousLength
+		}
 
-	contentLength := req.Headers.Get("Content-Length")
+		err = checkTransferEncoding(req, transferEncodings)
+		if err != nil {
+			return
+		}
 
-	if contentLength != "" {
-		rawLen = contentLength.String()
+		req.Trailers = make(Headers)
+		req.Body = newConnBody(newChunkedBody(reader, req.Trailers, opts))
+		return
 	}
-	bodyLen, err := strconv.Atoi(rawLen)
 
+	bodyLen, err := parseContentLength(contentLengths)
 	if err != nil {
-		err = httperr.ErrInvalidContentLength
 		return
 	}
 
 	if bodyLen == 0 {
+		req.Body = NoBody
 		return
 	}
 
-	// Read the whole body at once in the buffer.
-	buffer := make([]byte, bodyLen)
-	_, err = conn.Read(buffer)
-	req.Body = bytes.NewReader(buffer)
+	req.Body = newConnBody(&fixedLengthBody{reader: reader, remaining: bodyLen})
+
+	return
+}
+
+/*
+Checks the transfer codings of the request. Only chunked is implemented, the others are answered with 501.
+
+The chunked coding must be applied exactly once and last since it is what ends the body. HTTP/1.0 does not know transfer
+codings so a proxy in front could have forwarded the body with another length.
+*/
+func checkTransferEncoding(req *HttpRequest, values []HeaderValue) error {
+	if !req.ProtoAtLeast(1, 1) {
+		return fmt.Errorf("%w: transfer encoding in an HTTP/1.0 request", httperr.ErrInvalidTransferCoding)
+	}
+
+	codings := []string{}
+	for _, value := range values {
+		for _, part := range strings.Split(value.String(), ",") {
+			coding := strings.ToLower(strings.Trim(part, " \t"))
+
+			// Empty list elements are allowed by the list syntax, but a value made only of them is not.
+			if coding == "" {
+				continue
+			}
+
+			// The transfer codings do not take parameters except the obsolete ones we do not implement anyway.
+			if !isToken(coding) {
+				return fmt.Errorf("%w: %q", httperr.ErrInvalidTransferCoding, coding)
+			}
+
+			codings = append(codings, coding)
+		}
+	}
+
+	// A coding we do not understand is answered with 501 whatever its position. Ref - https://www.rfc-editor.org/rfc/rfc9112#section-6.1
+	for _, coding := range codings {
+		if coding != "chunked" {
+			return fmt.Errorf("%w: %s", httperr.ErrUnsupportedTransferCoding, coding)
+		}
+	}
+
+	if len(codings) == 0 {
+		return fmt.Errorf("%w: chunked must be the final coding", httperr.ErrInvalidTransferCoding)
+	}
+
+	if len(codings) > 1 {
+		return fmt.Errorf("%w: chunked applied more than once", httperr.ErrInvalidTransferCoding)
+	}
 
-	return err
+	return nil
+}
+
+/*
+Returns the length of the body from the Content-Length fields. A request without any has no body.
+
+Several fields or a comma separated list are only accepted when every value is the same, as some clients repeat the field.
+Ref - https://www.rfc-editor.org/rfc/rfc9110#section-8.6
+*/
+func parseContentLength(values []HeaderValue) (length int64, err error) {
+	rawLen := ""
+
+	for _, value := range values {
+		for _, part := range strings.Split(value.String(), ",") {
+			part = strings.Trim(part, " \t")
+
+			// An empty element would let ",5" pass as 5 here while another parser on the path rejects it or reads it differently.
+			if part == "" {
+				return 0, fmt.Errorf("%w: empty list element", httperr.ErrInvalidContentLength)
+			}
+
+			if rawLen != "" && part != rawLen {
+				return 0, fmt.Errorf("%w: conflicting values %q and %q", httperr.ErrInvalidContentLength, rawLen, part)
+			}
+
+			rawLen = part
+		}
+	}
+
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	// The length must only be made of digits so signs and spaces are rejected too.
+	if rawLen == "" || strings.TrimLeft(rawLen, "0123456789") != "" {
+		return 0, httperr.ErrInvalidContentLength
+	}
+
+	length, err = strconv.ParseInt(rawLen, 10, 64)
+	if err != nil {
+		return 0, httperr.ErrInvalidContentLength
+	}
+
+	return
 }
@@ -2,7 +2,9 @@ package gopherreq
 
 import (
 	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/cookie"
 	"io"
+	"strings"
 	"time"
 )
 
@@ -15,6 +17,53 @@ type HttpWireResponse struct {
 	Body         ResponseBody
 }
 
+/*
+HttpResponse is a response received by the Client.
+
+The body must be read until it ends or closed with Close, otherwise the connection is never released.
+*/
+type HttpResponse struct {
+	StatusCode    common.StatusCode
+	Reason        string
+	Version       string // The HTTP Version of the response as received.
+	ProtoMajor    int
+	ProtoMinor    int
+	Headers       Headers
+	Trailers      Headers            // The trailer fields sent after a chunked body. They are only available once the body is read completely.
+	ContentLength int64              // The length of the body. It is -1 when the body is chunked or delimited by the end of the connection.
+	Body          ResponseBodyReader // The body of the response. It is empty for HEAD requests and the statuses without a body.
+	Request       *HttpRequest       // The request this response answers.
+
+	body *clientBody
+}
+
+// Reads the rest of the body into memory and releases the connection.
+func (resp *HttpResponse) ReadAll() ([]byte, error) {
+	defer resp.Close()
+
+	data := []byte{}
+	for {
+		chunk, err := resp.Body.Read()
+		data = append(data, chunk...)
+
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+	}
+}
+
+// Releases the connection of the response. The part of the body which was not read is lost.
+func (resp *HttpResponse) Close() error {
+	if resp.body == nil {
+		return nil
+	}
+
+	return resp.body.Close()
+}
+
 type ResponseLine struct {
 	Code    common.StatusCode
 	Version string
@@ -177,13 +226,98 @@ var httpStatusPhraseReasons = map[common.StatusCode]string{
 	NETWORK_AUTH_REQUIRED:      "Network Authentication Required",
 }
 
+// Adds a Set-Cookie header for the cookie after validating it. Every cookie is sent in its own header.
+func (resp *HttpWireResponse) SetCookie(c cookie.Cookie) error {
+	return setCookieHeader(resp.Headers, c)
+}
+
+// Asks the client to delete the cookie. The path and domain must match the ones the cookie was set with.
+func (resp *HttpWireResponse) DeleteCookie(name string, path string, domain string) error {
+	return setCookieHeader(resp.Headers, newDeletionCookie(name, path, domain))
+}
+
+// Adds a Set-Cookie header for the cookie to the response of the handler. It must be called before the body is written.
+func SetCookie(w ResponseWriter, c cookie.Cookie) error {
+	return setCookieHeader(w.Header(), c)
+}
+
+// Asks the client to delete the cookie from the response of the handler. It must be called before the body is written.
+func DeleteCookie(w ResponseWriter, name string, path string, domain string) error {
+	return setCookieHeader(w.Header(), newDeletionCookie(name, path, domain))
+}
+
+func setCookieHeader(headers Headers, c cookie.Cookie) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	headers.Apsert("Set-Cookie", HeaderValue(c.String()))
+
+	return nil
+}
+
+/*
+An expired cookie with an empty value. Both Max-Age and Expires are set for the clients which only understand the older attribute.
+
+The cookies with a name prefix are only accepted by the clients with the attributes the prefix requires, the deletion included, so
+they are forced. A __Host- cookie always has the path "/" and no domain whatever the caller passed.
+*/
+func newDeletionCookie(name string, path string, domain string) cookie.Cookie {
+	c := cookie.Cookie{
+		Name:    name,
+		Path:    path,
+		Domain:  domain,
+		MaxAge:  -1,
+		Expires: time.Unix(0, 0),
+	}
+
+	if strings.HasPrefix(name, "__Secure-") || strings.HasPrefix(name, "__Host-") {
+		c.Secure = true
+	}
+	if strings.HasPrefix(name, "__Host-") {
+		c.Path = "/"
+		c.Domain = ""
+	}
+
+	return c
+}
+
 // Standardize some headers that if not set may break the protocol.
 func (resp *HttpWireResponse) StandardizeHeaders() {
 	if resp.Headers.Get("Date") == "" {
 		resp.Headers.Apsert("Date", HeaderValue(time.Now().UTC().Format(time.RFC1123)))
 	}
 
+	// Chunked responses are delimited by the last chunk and the length must not be sent along with it.
+	if resp.IsChunked() {
+		resp.Headers.Remove("Content-Length")
+		return
+	}
+
+	if !bodyAllowedForStatus(resp.ResponseLine.Code) {
+		resp.Headers.Remove("Content-Length")
+		return
+	}
+
 	if resp.Headers.Get("Content-Length") == "" {
 		resp.Headers.Apsert("Content-Length", HeaderValue("0"))
 	}
 }
+
+// Reports if the response is sent using the chunked transfer coding.
+func (resp *HttpWireResponse) IsChunked() bool {
+	return strings.EqualFold(resp.Headers.Get("Transfer-Encoding").String(), "chunked")
+}
+
+// Informational, 204 and 304 responses never carry a body.
+func bodyAllowedForStatus(code common.StatusCode) bool {
+	switch {
+	case code >= 100 && code < 200:
+		return false
+	case code == NO_CONTENT, code == NOT_MODIFIED:
+		return false
+	}
+
+	return true
+}
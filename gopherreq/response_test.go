@@ -0,0 +1,35 @@
+package gopherreq
+
+import (
+	"testing"
+)
+
+// The deletion cookie must satisfy the rules of a name prefix or the client ignores it.
+func TestDeleteCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		domain string
+		want   string
+	}{
+		{"id", "/app", "example.com", "id=; Path=/app; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0"},
+		{"__Secure-id", "/app", "example.com", "__Secure-id=; Path=/app; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; Secure"},
+		{"__Host-id", "/", "", "__Host-id=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; Secure"},
+		{"__Host-id", "/app", "example.com", "__Host-id=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; Secure"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name+test.path, func(t *testing.T) {
+			resp := HttpWireResponse{Headers: Headers{}}
+
+			err := resp.DeleteCookie(test.name, test.path, test.domain)
+			if err != nil {
+				t.Fatalf("DeleteCookie: %v", err)
+			}
+
+			if got := resp.Headers.Get("Set-Cookie").String(); got != test.want {
+				t.Fatalf("Set-Cookie = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package gopherreq
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Retries of a request when the client does not set it.
+const DEFAULT_MAX_RETRIES = 2
+
+// Wait before the first retry when the client does not set it.
+const DEFAULT_RETRY_BASE_DELAY = 100 * time.Millisecond
+
+// Longest wait between two attempts when the client does not set it.
+const DEFAULT_RETRY_MAX_DELAY = 10 * time.Second
+
+/*
+Decides if the request is sent again after the attempt and how long to wait before.
+
+Only idempotent requests whose body can be sent again are retried, up to MaxRetries. They are retried when the connection
+failed or timed out, and when the server answers 429 Too Many Requests or 503 Service Unavailable. The wait grows exponentially
+with some jitter, unless the server asks for a wait with Retry-After. When the wait is longer than RetryMaxDelay or than what is
+left of the context, the response is returned instead.
+*/
+func (c *Client) retryDelay(req *HttpRequest, resp *HttpResponse, err error, attempt int, rewindable bool) (time.Duration, bool) {
+	if attempt >= c.maxRetries() || !rewindable || !req.Method.IsIdempotent() || req.Context().Err() != nil {
+		return 0, false
+	}
+
+	if err != nil {
+		return c.backoff(attempt), isConnError(err)
+	}
+
+	if resp.StatusCode != TOO_MANY_REQUESTS && resp.StatusCode != SERVICE_UNAVAILABLE {
+		return 0, false
+	}
+
+	delay := c.backoff(attempt)
+	if retryAfter, ok := parseRetryAfter(resp.Headers.Get("Retry-After").String(), time.Now()); ok {
+		delay = retryAfter
+	}
+
+	if delay > c.retryMaxDelay() {
+		return 0, false
+	}
+
+	if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < delay {
+		return 0, false
+	}
+
+	return delay, true
+}
+
+// Returns the exponential wait before the retry. Half of it is random so the clients failing together do not retry together.
+func (c *Client) backoff(attempt int) time.Duration {
+	baseDelay := c.RetryBaseDelay
+	if baseDelay <= 0 {
+		baseDelay = DEFAULT_RETRY_BASE_DELAY
+	}
+
+	delay := c.retryMaxDelay()
+	if attempt < 32 && baseDelay<<attempt > 0 {
+		delay = min(baseDelay<<attempt, delay)
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (c *Client) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return DEFAULT_MAX_RETRIES
+	}
+
+	return max(c.MaxRetries, 0)
+}
+
+func (c *Client) retryMaxDelay() time.Duration {
+	if c.RetryMaxDelay <= 0 {
+		return DEFAULT_RETRY_MAX_DELAY
+	}
+
+	return c.RetryMaxDelay
+}
+
+/*
+Parses the Retry-After header which holds either a number of seconds or a date.
+
+	Retry-After = HTTP-date / delay-seconds
+
+Ref - https://www.rfc-editor.org/rfc/rfc9110#section-10.2.3
+*/
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := time.Parse(time.RFC1123, value)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
+
+// Reports if the error comes from the connection rather than from the request or the response being invalid.
+func isConnError(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// Waits for the delay unless the context ends first.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,253 @@
+package gopherreq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	client := &Client{MaxRetries: 2, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: 5 * time.Second}
+
+	response := func(status common.StatusCode, retryAfter string) *HttpResponse {
+		resp := &HttpResponse{StatusCode: status, Headers: Headers{}}
+		if retryAfter != "" {
+			resp.Headers.Set("Retry-After", HeaderValue(retryAfter))
+		}
+		return resp
+	}
+
+	// Stands for the random exponential wait, which is only checked to be within its bounds.
+	const backoff = time.Duration(-1)
+
+	tests := []struct {
+		name       string
+		method     common.HttpMethod
+		resp       *HttpResponse
+		err        error
+		attempt    int
+		rewindable bool
+		retry      bool
+		delay      time.Duration // The wait expected, or backoff for the exponential one of the attempt.
+	}{
+		{"connection reset", common.Get, nil, syscall.ECONNRESET, 0, true, true, backoff},
+		{"response cut short", common.Get, nil, errNoResponse, 0, true, true, backoff},
+		{"attempt timed out", common.Put, nil, fmt.Errorf("%w: read", context.DeadlineExceeded), 1, true, true, backoff},
+		{"invalid response", common.Get, nil, fmt.Errorf("%w: bad status line", httperr.ErrInvalidResponse), 0, true, false, 0},
+		{"POST not idempotent", common.Post, nil, syscall.ECONNRESET, 0, true, false, 0},
+		{"PATCH not idempotent", common.Patch, response(SERVICE_UNAVAILABLE, ""), nil, 0, true, false, 0},
+		{"body not rewindable", common.Put, nil, syscall.ECONNRESET, 0, false, false, 0},
+		{"retries used up", common.Get, nil, syscall.ECONNRESET, 2, true, false, 0},
+		{"503", common.Get, response(SERVICE_UNAVAILABLE, ""), nil, 0, true, true, backoff},
+		{"429 with Retry-After seconds", common.Get, response(TOO_MANY_REQUESTS, "2"), nil, 0, true, true, 2 * time.Second},
+		{"Retry-After zero", common.Get, response(SERVICE_UNAVAILABLE, "0"), nil, 1, true, true, 0},
+		{"Retry-After over the maximum", common.Get, response(SERVICE_UNAVAILABLE, "60"), nil, 0, true, false, 0},
+		{"invalid Retry-After", common.Get, response(SERVICE_UNAVAILABLE, "soon"), nil, 0, true, true, backoff},
+		{"500", common.Get, response(INTERNAL_SERVER_ERROR, ""), nil, 0, true, false, 0},
+		{"200", common.Get, response(OK, ""), nil, 0, true, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &HttpRequest{Method: test.method}
+
+			delay, retry := client.retryDelay(req, test.resp, test.err, test.attempt, test.rewindable)
+			if retry != test.retry {
+				t.Fatalf("retry = %v, want %v", retry, test.retry)
+			}
+			if !retry {
+				return
+			}
+
+			if test.delay != backoff && delay != test.delay {
+				t.Fatalf("delay = %v, want %v", delay, test.delay)
+			}
+			if test.delay == backoff && (delay < 50*time.Millisecond<<test.attempt || delay > 100*time.Millisecond<<test.attempt) {
+				t.Fatalf("delay = %v, want the backoff of attempt %d", delay, test.attempt)
+			}
+		})
+	}
+}
+
+// A wait which does not fit in what is left of the context returns the response instead.
+func TestRetryDelayContextDeadline(t *testing.T) {
+	client := &Client{}
+	resp := &HttpResponse{StatusCode: SERVICE_UNAVAILABLE, Headers: Headers{}}
+	resp.Headers.Set("Retry-After", "2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, retry := client.retryDelay((&HttpRequest{Method: common.Get}).WithContext(ctx), resp, nil, 0, true); retry {
+		t.Fatal("retried after the deadline of the context")
+	}
+
+	cancel()
+
+	if _, retry := client.retryDelay((&HttpRequest{Method: common.Get}).WithContext(ctx), nil, syscall.ECONNRESET, 0, true); retry {
+		t.Fatal("retried with a cancelled context")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := &Client{RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{40, time.Second},
+		{63, time.Second},
+	}
+
+	for _, test := range tests {
+		// Half of the wait is random so every attempt is sampled a few times.
+		for range 50 {
+			if delay := client.backoff(test.attempt); delay < test.max/2 || delay > test.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", test.attempt, delay, test.max/2, test.max)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Fri, 01 Mar 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 01 Mar 2024 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"1.5", 0, false},
+		{"99999999999", 0, false},
+		{"tomorrow", 0, false},
+	}
+
+	for _, test := range tests {
+		delay, ok := parseRetryAfter(test.value, now)
+		if delay != test.delay || ok != test.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", test.value, delay, ok, test.delay, test.ok)
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var hits atomic.Int32
+	server := startTestServer(t, Config{
+		Handler: HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+			io.Copy(io.Discard, req.Body)
+
+			// Every third request succeeds.
+			if hits.Add(1)%3 != 0 {
+				Error(w, SERVICE_UNAVAILABLE)
+				return
+			}
+			w.Write([]byte("ok"))
+		}),
+	})
+	address := server.Addrs()[0].String()
+
+	tests := []struct {
+		name       string
+		method     common.HttpMethod
+		body       RequestBody
+		maxRetries int
+		status     common.StatusCode
+		hits       int32
+	}{
+		{"GET retried until it succeeds", common.Get, NoBody, 2, OK, 3},
+		{"PUT with a rewindable body", common.Put, strings.NewReader("data"), 2, OK, 3},
+		{"retries used up", common.Get, NoBody, 1, SERVICE_UNAVAILABLE, 2},
+		{"retries disabled", common.Get, NoBody, -1, SERVICE_UNAVAILABLE, 1},
+		{"POST not retried", common.Post, strings.NewReader("data"), 2, SERVICE_UNAVAILABLE, 1},
+		{"body not rewindable", common.Put, io.MultiReader(strings.NewReader("data")), 2, SERVICE_UNAVAILABLE, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hits.Store(0)
+			client := &Client{Transport: &Transport{}, MaxRetries: test.maxRetries, RetryBaseDelay: time.Millisecond}
+
+			resp, err := client.Do(HttpRequest{Method: test.method, URI: url.URL{Scheme: "http", Host: address, Path: "/"}, Body: test.body})
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			resp.ReadAll()
+
+			if resp.StatusCode != test.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, test.status)
+			}
+			if count := hits.Load(); count != test.hits {
+				t.Fatalf("server got %d requests, want %d", count, test.hits)
+			}
+		})
+	}
+}
+
+// An attempt which does not get its response in time is abandoned and retried, while Timeout bounds the whole exchange.
+func TestClientAttemptTimeout(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	defer close(release)
+
+	server := startTestServer(t, Config{
+		Handler: HandlerFunc(func(w ResponseWriter, req *HttpRequest) {
+			if hits.Add(1) == 1 || req.URI.Path == "/hang" {
+				<-release
+			}
+			w.Write([]byte("ok"))
+		}),
+	})
+	address := server.Addrs()[0].String()
+
+	client := &Client{Transport: &Transport{}, AttemptTimeout: 50 * time.Millisecond, RetryBaseDelay: time.Millisecond}
+
+	start := time.Now()
+	resp, err := client.Do(HttpRequest{URI: url.URL{Scheme: "http", Host: address, Path: "/"}})
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if body, err := resp.ReadAll(); err != nil || string(body) != "ok" {
+		t.Fatalf("body = %q, %v", body, err)
+	}
+
+	if count := hits.Load(); count != 2 {
+		t.Fatalf("server got %d requests, want the timed out one retried once", count)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v, want the first attempt abandoned after its timeout", elapsed)
+	}
+
+	// Every attempt times out and the whole exchange stops at Timeout.
+	client.Timeout = 120 * time.Millisecond
+	client.MaxRetries = 10
+
+	start = time.Now()
+	_, err = client.Do(HttpRequest{URI: url.URL{Scheme: "http", Host: address, Path: "/hang"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v, want Timeout to stop the retries", elapsed)
+	}
+}
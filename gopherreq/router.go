@@ -0,0 +1,386 @@
+package gopherreq
+
+import (
+	"fmt"
+	"gopherreq/gopherreq/common"
+	"slices"
+	"strings"
+)
+
+/*
+Router matches the method and path of the request against the registered routes and dispatches to the handler.
+
+Patterns are made of segments separated by "/":
+  - Static segments match the path segment exactly. Eg. /users
+  - Parameter segments start with ":" and capture a single path segment. Eg. /users/:id
+  - Wildcard segments start with "*" and capture the rest of the path. It must be the last segment. Eg. /static/*path
+
+When multiple routes match, static segments win over parameters and parameters win over wildcards.
+If the path matches but the method does not, a 405 is sent with the Allow header set. HEAD requests are served by the GET
+route when the path has no HEAD route, and OPTIONS requests are answered with the Allow header when it has no OPTIONS route.
+
+Middlewares added with Use run for the matched routes only. Routes sharing a prefix and middlewares can be registered through a Group.
+*/
+type Router struct {
+	NotFound Handler // Called when no route matches the path. Defaults to NotFoundHandler.
+	routes   []*route
+	root     *RouteGroup
+}
+
+// RouteGroup registers routes under a common path prefix. Its middlewares run inside the ones of its parent groups and the router.
+type RouteGroup struct {
+	router      *Router
+	parent      *RouteGroup
+	prefix      string
+	middlewares []Middleware
+}
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	wildcardSegment
+)
+
+type routeSegment struct {
+	kind  segmentKind
+	value string // The literal for static segments and the capture name for parameters and wildcards.
+}
+
+type route struct {
+	method   common.HttpMethod
+	pattern  string
+	segments []routeSegment
+	handler  Handler
+	group    *RouteGroup
+}
+
+func NewRouter() *Router {
+	router := &Router{}
+	router.root = &RouteGroup{router: router}
+
+	return router
+}
+
+func (r *Router) rootGroup() *RouteGroup {
+	if r.root == nil {
+		r.root = &RouteGroup{router: r}
+	}
+
+	return r.root
+}
+
+// Adds middlewares which run for every matched route of the router.
+func (r *Router) Use(middlewares ...Middleware) {
+	r.rootGroup().Use(middlewares...)
+}
+
+// Creates a group of routes under the prefix. The middlewares only run for the routes of the group.
+func (r *Router) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return r.rootGroup().Group(prefix, middlewares...)
+}
+
+// Registers the handler for the method and pattern. It panics if the pattern is invalid or already registered for the method.
+func (r *Router) Handle(method common.HttpMethod, pattern string, handler Handler) {
+	r.rootGroup().Handle(method, pattern, handler)
+}
+
+func (r *Router) HandleFunc(method common.HttpMethod, pattern string, handler func(w ResponseWriter, req *HttpRequest)) {
+	r.Handle(method, pattern, HandlerFunc(handler))
+}
+
+func (r *Router) Get(pattern string, handler HandlerFunc) {
+	r.Handle(common.Get, pattern, handler)
+}
+
+func (r *Router) Post(pattern string, handler HandlerFunc) {
+	r.Handle(common.Post, pattern, handler)
+}
+
+func (r *Router) Put(pattern string, handler HandlerFunc) {
+	r.Handle(common.Put, pattern, handler)
+}
+
+func (r *Router) Delete(pattern string, handler HandlerFunc) {
+	r.Handle(common.Delete, pattern, handler)
+}
+
+func (r *Router) Patch(pattern string, handler HandlerFunc) {
+	r.Handle(common.Patch, pattern, handler)
+}
+
+// Registers a handler for HEAD. It is only needed to answer HEAD differently from GET, the GET route serves it otherwise.
+func (r *Router) Head(pattern string, handler HandlerFunc) {
+	r.Handle(common.Head, pattern, handler)
+}
+
+// Registers a handler for OPTIONS. Without it the router answers with the methods allowed for the path.
+func (r *Router) Options(pattern string, handler HandlerFunc) {
+	r.Handle(common.Options, pattern, handler)
+}
+
+// Adds middlewares which run for every route of the group including the ones of its sub groups.
+func (g *RouteGroup) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Creates a sub group. Its prefix is appended to the prefix of the group.
+func (g *RouteGroup) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:      g.router,
+		parent:      g,
+		prefix:      joinPattern(g.prefix, prefix),
+		middlewares: middlewares,
+	}
+}
+
+// Registers the handler for the method and the pattern relative to the prefix of the group.
+func (g *RouteGroup) Handle(method common.HttpMethod, pattern string, handler Handler) {
+	g.router.addRoute(method, joinPattern(g.prefix, pattern), handler, g)
+}
+
+func (g *RouteGroup) HandleFunc(method common.HttpMethod, pattern string, handler func(w ResponseWriter, req *HttpRequest)) {
+	g.Handle(method, pattern, HandlerFunc(handler))
+}
+
+func (g *RouteGroup) Get(pattern string, handler HandlerFunc) {
+	g.Handle(common.Get, pattern, handler)
+}
+
+func (g *RouteGroup) Post(pattern string, handler HandlerFunc) {
+	g.Handle(common.Post, pattern, handler)
+}
+
+func (g *RouteGroup) Put(pattern string, handler HandlerFunc) {
+	g.Handle(common.Put, pattern, handler)
+}
+
+func (g *RouteGroup) Delete(pattern string, handler HandlerFunc) {
+	g.Handle(common.Delete, pattern, handler)
+}
+
+func (g *RouteGroup) Patch(pattern string, handler HandlerFunc) {
+	g.Handle(common.Patch, pattern, handler)
+}
+
+// Registers a handler for HEAD. It is only needed to answer HEAD differently from GET, the GET route serves it otherwise.
+func (g *RouteGroup) Head(pattern string, handler HandlerFunc) {
+	g.Handle(common.Head, pattern, handler)
+}
+
+// Registers a handler for OPTIONS. Without it the router answers with the methods allowed for the path.
+func (g *RouteGroup) Options(pattern string, handler HandlerFunc) {
+	g.Handle(common.Options, pattern, handler)
+}
+
+// Joins the prefix of a group with a pattern. The root pattern of a group is the prefix itself.
+func joinPattern(prefix string, pattern string) string {
+	if prefix == "" {
+		return pattern
+	}
+
+	if pattern == "/" || pattern == "" {
+		return prefix
+	}
+
+	return strings.TrimSuffix(prefix, "/") + pattern
+}
+
+func (r *Router) addRoute(method common.HttpMethod, pattern string, handler Handler, group *RouteGroup) {
+	if handler == nil {
+		panic("gopherreq: nil handler for " + pattern)
+	}
+
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, existing := range r.routes {
+		if existing.method == method && existing.pattern == pattern {
+			panic(fmt.Sprintf("gopherreq: route %s %s is already registered", method, pattern))
+		}
+	}
+
+	r.routes = append(r.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+		group:    group,
+	})
+}
+
+func (r *Router) ServeHttp(w ResponseWriter, req *HttpRequest) {
+	pathSegments := splitPath(req.URI.Path)
+
+	var matched, fallback *route
+	var matchedParams, fallbackParams map[string]string
+	allowed := []string{}
+
+	for _, rt := range r.routes {
+		params, ok := rt.match(pathSegments)
+		if !ok {
+			continue
+		}
+
+		if !slices.Contains(allowed, string(rt.method)) {
+			allowed = append(allowed, string(rt.method))
+		}
+
+		switch {
+		case rt.method == req.Method:
+			if matched == nil || rt.moreSpecificThan(matched) {
+				matched = rt
+				matchedParams = params
+			}
+
+		case rt.method == common.Get && req.Method == common.Head:
+			if fallback == nil || rt.moreSpecificThan(fallback) {
+				fallback = rt
+				fallbackParams = params
+			}
+		}
+	}
+
+	if matched == nil && fallback != nil {
+		matched = fallback
+		matchedParams = fallbackParams
+	}
+
+	if matched != nil {
+		req.Params = matchedParams
+		matched.chain().ServeHttp(w, req)
+		return
+	}
+
+	if len(allowed) != 0 {
+		// The methods the router answers on its own for every path with a route.
+		if slices.Contains(allowed, string(common.Get)) && !slices.Contains(allowed, string(common.Head)) {
+			allowed = append(allowed, string(common.Head))
+		}
+		if !slices.Contains(allowed, string(common.Options)) {
+			allowed = append(allowed, string(common.Options))
+		}
+
+		slices.Sort(allowed)
+		w.Header().Set("Allow", HeaderValue(strings.Join(allowed, ", ")))
+
+		if req.Method == common.Options {
+			w.WriteHeader(NO_CONTENT)
+			return
+		}
+
+		Error(w, METHOD_NOT_ALLOWED)
+		return
+	}
+
+	notFound := r.NotFound
+	if notFound == nil {
+		notFound = NotFoundHandler
+	}
+	notFound.ServeHttp(w, req)
+}
+
+func parsePattern(pattern string) (segments []routeSegment, err error) {
+	if !strings.HasPrefix(pattern, "/") {
+		err = fmt.Errorf("gopherreq: pattern %q must start with /", pattern)
+		return
+	}
+
+	parts := splitPath(pattern)
+	segments = make([]routeSegment, 0, len(parts))
+
+	for index, part := range parts {
+		switch {
+		case strings.HasPrefix(part, ":"):
+			if len(part) == 1 {
+				err = fmt.Errorf("gopherreq: pattern %q has an unnamed parameter", pattern)
+				return
+			}
+			segments = append(segments, routeSegment{kind: paramSegment, value: part[1:]})
+
+		case strings.HasPrefix(part, "*"):
+			if index != len(parts)-1 {
+				err = fmt.Errorf("gopherreq: wildcard must be the last segment in pattern %q", pattern)
+				return
+			}
+
+			// An unnamed wildcard is stored under "*".
+			name := part[1:]
+			if name == "" {
+				name = "*"
+			}
+			segments = append(segments, routeSegment{kind: wildcardSegment, value: name})
+
+		default:
+			segments = append(segments, routeSegment{kind: staticSegment, value: part})
+		}
+	}
+
+	return
+}
+
+// Splits the path into its segments ignoring the leading and trailing slash. The root path returns no segments.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+
+	return strings.Split(path, "/")
+}
+
+// Matches the path segments against the route and returns the captured parameters.
+func (rt *route) match(pathSegments []string) (params map[string]string, ok bool) {
+	params = make(map[string]string)
+
+	for index, seg := range rt.segments {
+		if seg.kind == wildcardSegment {
+			params[seg.value] = strings.Join(pathSegments[index:], "/")
+			return params, true
+		}
+
+		if index >= len(pathSegments) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case staticSegment:
+			if seg.value != pathSegments[index] {
+				return nil, false
+			}
+		case paramSegment:
+			params[seg.value] = pathSegments[index]
+		}
+	}
+
+	if len(rt.segments) != len(pathSegments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// Wraps the handler of the route with the middlewares of its group and all the parent groups.
+func (rt *route) chain() Handler {
+	handler := rt.handler
+
+	for group := rt.group; group != nil; group = group.parent {
+		handler = Chain(handler, group.middlewares...)
+	}
+
+	return handler
+}
+
+// Compares the segments from left to right and reports if this route should win over the other one.
+func (rt *route) moreSpecificThan(other *route) bool {
+	for index := 0; index < len(rt.segments) && index < len(other.segments); index++ {
+		if rt.segments[index].kind != other.segments[index].kind {
+			return rt.segments[index].kind < other.segments[index].kind
+		}
+	}
+
+	return len(rt.segments) > len(other.segments)
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"errors"
+	"gopherreq/gopherreq/cookie"
+	"time"
+)
+
+// Largest cookie value the browsers are required to keep. Ref - https://www.rfc-editor.org/rfc/rfc6265#section-6.1
+const MAX_COOKIE_VALUE_BYTES = 4096
+
+// The name the values are bound to by the codec.
+const cookieStoreName = "session"
+
+/*
+CookieStore keeps the whole session in the cookie of the client, protected by the codec. Nothing is stored on the server so it
+scales without shared state, but the sessions can not be revoked before they expire and must stay small.
+
+Use a cookie.EncryptedCodec when the values must not be readable by the client.
+*/
+type CookieStore struct {
+	codec cookie.Codec
+}
+
+func NewCookieStore(codec cookie.Codec) *CookieStore {
+	return &CookieStore{codec: codec}
+}
+
+func (c *CookieStore) Load(value string) ([]byte, error) {
+	decoded, err := c.codec.Decode(cookieStoreName, value)
+	if err != nil {
+		// Tampered and expired values are both treated as a missing session.
+		if errors.Is(err, cookie.ErrSignatureInvalid) || errors.Is(err, cookie.ErrDecryptionFailed) || errors.Is(err, cookie.ErrCookieExpired) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	return []byte(decoded), nil
+}
+
+// The expiry is enforced by the manager from the times stored in the session.
+func (c *CookieStore) Save(id string, data []byte, expiry time.Time) (string, error) {
+	value, err := c.codec.Encode(cookieStoreName, string(data))
+	if err != nil {
+		return "", err
+	}
+
+	if len(value) > MAX_COOKIE_VALUE_BYTES {
+		return "", ErrCookieTooLarge
+	}
+
+	return value, nil
+}
+
+// The session is removed by deleting the cookie, which the manager does.
+func (c *CookieStore) Delete(id string) error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Prefix of the session files so the cleanup never removes the other files of the directory.
+const sessionFilePrefix = "session_"
+
+// Prefix of the files a session is written to before being renamed.
+const temporaryFilePrefix = "tmp_"
+
+// Age after which a temporary file is considered left over by a crashed save. A save in progress takes far less.
+const temporaryFileMaxAge = time.Hour
+
+/*
+FileStore keeps every session in its own file of a directory so they survive restarts.
+
+Each file holds the expiry as a unix timestamp followed by the session. The expired files are removed when loaded or by
+DeleteExpired, which also removes the temporary files left over by a process stopped in the middle of a save.
+*/
+type FileStore struct {
+	dir string
+}
+
+// Creates the store and its directory. The directory is only accessible by the owner since sessions often hold credentials.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) Load(value string) ([]byte, error) {
+	path, err := f.path(value)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8 {
+		return nil, fmt.Errorf("session file %s is corrupted", path)
+	}
+
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	if !expiry.After(time.Now()) {
+		os.Remove(path)
+		return nil, ErrSessionNotFound
+	}
+
+	return data[8:], nil
+}
+
+func (f *FileStore) Save(id string, data []byte, expiry time.Time) (string, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return "", err
+	}
+
+	contents := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(data)), uint64(expiry.Unix()))
+	contents = append(contents, data...)
+
+	// The session is written to a temporary file first so a concurrent Load never reads a partial file.
+	temporary, err := os.CreateTemp(f.dir, temporaryFilePrefix)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = temporary.Write(contents)
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporary.Name())
+		return "", err
+	}
+
+	err = os.Rename(temporary.Name(), path)
+	if err != nil {
+		os.Remove(temporary.Name())
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (f *FileStore) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Removes the files of the expired sessions and the stale temporary files. Call it periodically, the store does not run any background work.
+func (f *FileStore) DeleteExpired() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if strings.HasPrefix(entry.Name(), temporaryFilePrefix) {
+			info, err := entry.Info()
+			if err == nil && now.Sub(info.ModTime()) > temporaryFileMaxAge {
+				os.Remove(filepath.Join(f.dir, entry.Name()))
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(entry.Name(), sessionFilePrefix) {
+			continue
+		}
+
+		path := filepath.Join(f.dir, entry.Name())
+
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		header := make([]byte, 8)
+		_, err = file.Read(header)
+		file.Close()
+
+		if err != nil || !time.Unix(int64(binary.BigEndian.Uint64(header)), 0).After(now) {
+			os.Remove(path)
+		}
+	}
+
+	return nil
+}
+
+// The ID comes from the client so it is checked before being used in a path.
+func (f *FileStore) path(id string) (string, error) {
+	if !isValidID(id) {
+		return "", ErrInvalidSessionID
+	}
+
+	return filepath.Join(f.dir, sessionFilePrefix+id), nil
+}
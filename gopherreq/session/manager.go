@@ -0,0 +1,308 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/cookie"
+	"time"
+)
+
+const (
+	DEFAULT_COOKIE_NAME      = "session_id"
+	DEFAULT_IDLE_TIMEOUT     = 30 * time.Minute
+	DEFAULT_ABSOLUTE_TIMEOUT = 24 * time.Hour
+)
+
+// The fraction of the idle timeout after which an unchanged session is saved again to extend its expiry.
+const TOUCH_FRACTION = 10
+
+type Config struct {
+	Store      Store           // Where the sessions are kept. It is required.
+	CookieName string          // Name of the session cookie. Defaults to DEFAULT_COOKIE_NAME.
+	Path       string          // Path of the session cookie. Defaults to "/".
+	Domain     string          // Domain of the session cookie. Empty keeps it to the host which set it.
+	Secure     bool            // Only sends the cookie over HTTPS. Set it for every site served over HTTPS.
+	SameSite   cookie.SameSite // Defaults to Lax.
+	Persistent bool            // Sends Max-Age so the cookie outlives the browser session. By default it is removed when the browser closes.
+
+	IdleTimeout     time.Duration // The session expires when it is not used for this long. Defaults to DEFAULT_IDLE_TIMEOUT.
+	AbsoluteTimeout time.Duration // The session expires this long after it was created whatever its use. Defaults to DEFAULT_ABSOLUTE_TIMEOUT.
+}
+
+// Manager loads the session of every request from the session cookie and saves it back once the handler responds.
+type Manager struct {
+	store           Store
+	cookieName      string
+	path            string
+	domain          string
+	secure          bool
+	sameSite        cookie.SameSite
+	persistent      bool
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+}
+
+type contextKey struct{}
+
+func NewManager(cfg Config) (*Manager, error) {
+	if cfg.Store == nil {
+		return nil, errors.New("session: a store is required")
+	}
+
+	m := &Manager{
+		store:           cfg.Store,
+		cookieName:      cfg.CookieName,
+		path:            cfg.Path,
+		domain:          cfg.Domain,
+		secure:          cfg.Secure,
+		sameSite:        cfg.SameSite,
+		persistent:      cfg.Persistent,
+		idleTimeout:     cfg.IdleTimeout,
+		absoluteTimeout: cfg.AbsoluteTimeout,
+	}
+
+	if m.cookieName == "" {
+		m.cookieName = DEFAULT_COOKIE_NAME
+	}
+	if m.path == "" {
+		m.path = "/"
+	}
+	if m.sameSite == 0 {
+		m.sameSite = cookie.SameSiteLaxMode
+	}
+	if m.idleTimeout <= 0 {
+		m.idleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+	if m.absoluteTimeout <= 0 {
+		m.absoluteTimeout = DEFAULT_ABSOLUTE_TIMEOUT
+	}
+
+	// The cookie is checked once here so a bad configuration does not fail every response.
+	c := m.newCookie("", 0)
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+/*
+Middleware loads the session before calling the handler and saves it when the handler starts its response, since the cookie can
+not be sent after the header. Read the session with FromRequest.
+
+Changes made once the response started, like flashes read while rendering, are saved again when the handler returns. Those which
+need a new cookie, like a new session, RegenerateID or any change with the cookie store, can not reach the client anymore and are
+logged as errors.
+
+A session which was never changed is not saved, so clients which do not use the session do not get a cookie. Place it inside
+Recovery so a panicking handler does not save a half updated session.
+*/
+func (m *Manager) Middleware(next gopherreq.Handler) gopherreq.Handler {
+	return gopherreq.HandlerFunc(func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		s := m.load(req)
+
+		writer := &sessionWriter{ResponseWriter: w}
+		writer.commit = func() {
+			m.save(writer.ResponseWriter, req, s, false)
+		}
+
+		next.ServeHttp(writer, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+
+		if writer.committed {
+			m.save(writer.ResponseWriter, req, s, true)
+			return
+		}
+
+		writer.commitOnce()
+	})
+}
+
+// Returns the session of the request. It is nil when the request did not go through Manager.Middleware.
+func FromRequest(req *gopherreq.HttpRequest) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+
+	return s
+}
+
+// Loads the session of the cookie. A new session is started when there is none or when it has expired.
+func (m *Manager) load(req *gopherreq.HttpRequest) *Session {
+	now := time.Now()
+
+	c, exists := req.Cookies.Get(m.cookieName)
+	if !exists {
+		return newSession(now)
+	}
+
+	data, err := m.store.Load(c.Value)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			req.Logger().Error("loading session", "error", err)
+		}
+		return newSession(now)
+	}
+
+	s, err := decodeSession(data)
+	if err != nil {
+		req.Logger().Error("decoding session", "error", err)
+		return newSession(now)
+	}
+	s.cookieValue = c.Value
+
+	if now.Sub(s.lastAccess) > m.idleTimeout || now.Sub(s.created) > m.absoluteTimeout {
+		m.store.Delete(s.id)
+		return newSession(now)
+	}
+
+	return s
+}
+
+/*
+Saves the session if it changed and sets the cookie. The errors can not be sent to the client anymore so they are only logged.
+
+Late is set once the response started. The store is still updated but the cookie can not change anymore, so the changes which
+need another cookie value are lost and logged.
+*/
+func (m *Manager) save(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest, s *Session, late bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.previousID != "" {
+		m.store.Delete(s.previousID)
+		s.previousID = ""
+	}
+
+	if s.destroyed {
+		if s.cookieValue != "" {
+			m.store.Delete(s.id)
+
+			// Server side stores keep only the ID in the cookie, so removing the session from the store is enough.
+			if !late {
+				// Without the deletion the cookie store would keep the whole session valid on the client.
+				err := gopherreq.SetCookie(w, m.deletionCookie())
+				if err != nil {
+					req.Logger().Error("deleting session cookie", "error", err)
+				}
+			} else if s.cookieValue != s.id {
+				req.Logger().Error("session destroyed after the response started, its cookie could not be deleted")
+			}
+			s.cookieValue = ""
+		}
+		return
+	}
+
+	// An empty new session is not worth a cookie.
+	if s.isNew && len(s.values) == 0 && len(s.flashes) == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	// The unchanged sessions are saved from time to time so their idle expiry moves forward while they are used.
+	touch := !late && now.Sub(s.lastAccess) > m.idleTimeout/TOUCH_FRACTION
+	if !s.modified && !touch {
+		return
+	}
+
+	if late && s.cookieValue == "" {
+		req.Logger().Error("session changed after the response started, the client has no cookie for it")
+		return
+	}
+
+	s.lastAccess = now
+
+	data, err := s.encode()
+	if err != nil {
+		req.Logger().Error("encoding session", "error", err)
+		return
+	}
+
+	expiry := s.lastAccess.Add(m.idleTimeout)
+	if absolute := s.created.Add(m.absoluteTimeout); absolute.Before(expiry) {
+		expiry = absolute
+	}
+
+	value, err := m.store.Save(s.id, data, expiry)
+	if err != nil {
+		req.Logger().Error("saving session", "error", err)
+		return
+	}
+
+	if late {
+		if value != s.cookieValue {
+			req.Logger().Error("session changed after the response started, its cookie could not be updated")
+		}
+		s.modified = false
+		return
+	}
+
+	maxAge := 0
+	if m.persistent {
+		maxAge = max(int(expiry.Sub(now).Seconds()), 1)
+	}
+
+	err = gopherreq.SetCookie(w, m.newCookie(value, maxAge))
+	if err != nil {
+		req.Logger().Error("setting session cookie", "error", err)
+		return
+	}
+
+	s.cookieValue = value
+	s.isNew = false
+	s.modified = false
+}
+
+// The session cookie expired. It is built like the cookie which was set so the client matches it, and a name prefix is satisfied.
+func (m *Manager) deletionCookie() cookie.Cookie {
+	c := m.newCookie("", -1)
+	c.Expires = time.Unix(0, 0)
+
+	return c
+}
+
+// The session cookie is never readable by scripts.
+func (m *Manager) newCookie(value string, maxAge int) cookie.Cookie {
+	return cookie.Cookie{
+		Name:     m.cookieName,
+		Value:    value,
+		Path:     m.path,
+		Domain:   m.domain,
+		MaxAge:   maxAge,
+		Secure:   m.secure,
+		HttpOnly: true,
+		SameSite: m.sameSite,
+	}
+}
+
+// Wraps the ResponseWriter of the handler to save the session right before the header is sent.
+type sessionWriter struct {
+	gopherreq.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (w *sessionWriter) commitOnce() {
+	if w.committed {
+		return
+	}
+
+	w.committed = true
+	w.commit()
+}
+
+func (w *sessionWriter) WriteHeader(code common.StatusCode) {
+	w.commitOnce()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.commitOnce()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionWriter) Flush() error {
+	w.commitOnce()
+	return w.ResponseWriter.Flush()
+}
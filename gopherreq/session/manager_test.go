@@ -0,0 +1,210 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/cookie"
+	"strings"
+	"testing"
+)
+
+// Records the response of the handler in memory.
+type recorder struct {
+	headers gopherreq.Headers
+	code    common.StatusCode
+	body    bytes.Buffer
+}
+
+func (r *recorder) Header() gopherreq.Headers { return r.headers }
+
+func (r *recorder) WriteHeader(code common.StatusCode) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	r.WriteHeader(200)
+	return r.body.Write(data)
+}
+
+func (r *recorder) Flush() error { return nil }
+
+// Every store is tested with the same scenarios since the manager must behave the same whichever keeps the sessions.
+func testStores(t *testing.T) map[string]Store {
+	codec, err := cookie.NewSignedCodec(0, []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewSignedCodec: %v", err)
+	}
+
+	files, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	return map[string]Store{
+		"memory": NewMemoryStore(0),
+		"file":   files,
+		"cookie": NewCookieStore(codec),
+	}
+}
+
+// Runs the handler behind the middleware with the session cookie value, if any, and returns the Set-Cookie headers.
+func serve(t *testing.T, m *Manager, value string, handler func(s *Session)) []string {
+	t.Helper()
+
+	req := &gopherreq.HttpRequest{Method: common.Get, Headers: gopherreq.Headers{}, Cookies: cookie.NewCookieList()}
+	if value != "" {
+		req.Cookies.Add(cookie.Cookie{Name: m.cookieName, Value: value})
+	}
+
+	w := &recorder{headers: gopherreq.Headers{}}
+
+	m.Middleware(gopherreq.HandlerFunc(func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		handler(FromRequest(req))
+		w.Write([]byte("ok"))
+	})).ServeHttp(w, req)
+
+	setCookies := []string{}
+	for _, value := range w.headers.GetAllValues("Set-Cookie") {
+		setCookies = append(setCookies, value.String())
+	}
+
+	return setCookies
+}
+
+// Returns the value of the only session cookie set.
+func cookieValue(t *testing.T, setCookies []string) string {
+	t.Helper()
+
+	if len(setCookies) != 1 {
+		t.Fatalf("got %d Set-Cookie headers %q, want 1", len(setCookies), setCookies)
+	}
+
+	c, err := cookie.ParseSetCookie(setCookies[0])
+	if err != nil {
+		t.Fatalf("ParseSetCookie(%q): %v", setCookies[0], err)
+	}
+
+	return c.Value
+}
+
+func TestSaveOnlyIfModified(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewManager(Config{Store: store})
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+
+			if setCookies := serve(t, m, "", func(s *Session) {}); len(setCookies) != 0 {
+				t.Fatalf("unused new session set %q", setCookies)
+			}
+
+			value := cookieValue(t, serve(t, m, "", func(s *Session) { s.Set("user", "ada") }))
+
+			if setCookies := serve(t, m, value, func(s *Session) { s.Get("user") }); len(setCookies) != 0 {
+				t.Fatalf("unchanged session set %q", setCookies)
+			}
+
+			serve(t, m, value, func(s *Session) {
+				if user, _ := s.GetString("user"); user != "ada" {
+					t.Fatalf("user = %q, want ada", user)
+				}
+			})
+
+			updated := cookieValue(t, serve(t, m, value, func(s *Session) { s.Set("user", "grace") }))
+
+			serve(t, m, updated, func(s *Session) {
+				if user, _ := s.GetString("user"); user != "grace" {
+					t.Fatalf("user after the update = %q, want grace", user)
+				}
+			})
+		})
+	}
+}
+
+func TestRegenerateID(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewManager(Config{Store: store})
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+
+			var oldID, newID string
+			value := cookieValue(t, serve(t, m, "", func(s *Session) {
+				s.Set("user", "ada")
+				oldID = s.ID()
+			}))
+
+			regenerated := cookieValue(t, serve(t, m, value, func(s *Session) {
+				s.RegenerateID()
+				newID = s.ID()
+			}))
+
+			if oldID == newID {
+				t.Fatal("RegenerateID kept the ID")
+			}
+
+			serve(t, m, regenerated, func(s *Session) {
+				if s.ID() != newID {
+					t.Fatalf("ID = %q, want the regenerated %q", s.ID(), newID)
+				}
+				if user, _ := s.GetString("user"); user != "ada" {
+					t.Fatalf("user = %q, want the values kept", user)
+				}
+			})
+
+			// The cookie store can not revoke a value, the server side stores must forget the old ID.
+			if name != "cookie" {
+				if _, err := store.Load(oldID); !errors.Is(err, ErrSessionNotFound) {
+					t.Fatalf("Load(old ID) = %v, want ErrSessionNotFound", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewManager(Config{Store: store, CookieName: "__Host-session", Secure: true})
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+
+			var id string
+			value := cookieValue(t, serve(t, m, "", func(s *Session) {
+				s.Set("user", "ada")
+				id = s.ID()
+			}))
+
+			setCookies := serve(t, m, value, func(s *Session) { s.Destroy() })
+			if len(setCookies) != 1 {
+				t.Fatalf("got %d Set-Cookie headers %q, want the deletion", len(setCookies), setCookies)
+			}
+
+			deletion := setCookies[0]
+			for _, want := range []string{"__Host-session=;", "Path=/", "Max-Age=0", "Secure", "HttpOnly", "SameSite=Lax"} {
+				if !strings.Contains(deletion, want) {
+					t.Fatalf("deletion cookie %q does not contain %q", deletion, want)
+				}
+			}
+
+			if name != "cookie" {
+				if _, err := store.Load(id); !errors.Is(err, ErrSessionNotFound) {
+					t.Fatalf("Load(destroyed ID) = %v, want ErrSessionNotFound", err)
+				}
+			}
+
+			serve(t, m, value, func(s *Session) {
+				if name != "cookie" && !s.IsNew() {
+					t.Fatal("the destroyed session was loaded again")
+				}
+			})
+		})
+	}
+}
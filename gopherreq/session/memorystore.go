@@ -0,0 +1,110 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the sessions in the memory of the process. They are lost on restart and are not shared between servers.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+	done     chan struct{}
+	once     sync.Once
+}
+
+type memoryEntry struct {
+	data   []byte
+	expiry time.Time
+}
+
+// Creates the store. The expired sessions are evicted every cleanupInterval until Close is called. Zero disables the eviction.
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	store := &MemoryStore{
+		sessions: make(map[string]memoryEntry),
+		done:     make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go store.evictLoop(cleanupInterval)
+	}
+
+	return store
+}
+
+func (m *MemoryStore) Load(value string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.sessions[value]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
+	if !entry.expiry.After(time.Now()) {
+		delete(m.sessions, value)
+		return nil, ErrSessionNotFound
+	}
+
+	return entry.data, nil
+}
+
+func (m *MemoryStore) Save(id string, data []byte, expiry time.Time) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = memoryEntry{data: data, expiry: expiry}
+
+	return id, nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+// Returns the number of sessions stored, including the expired ones which were not evicted yet.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.sessions)
+}
+
+// Stops the eviction of the expired sessions.
+func (m *MemoryStore) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.evict(now)
+		}
+	}
+}
+
+func (m *MemoryStore) evict(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, entry := range m.sessions {
+		if !entry.expiry.After(now) {
+			delete(m.sessions, id)
+		}
+	}
+}
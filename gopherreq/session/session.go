@@ -0,0 +1,250 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"sync"
+	"time"
+)
+
+/*
+Session holds the values kept for a client between its requests. It is loaded by the Manager middleware and can be read with
+FromRequest.
+
+The values are encoded with encoding/gob so the types stored other than the basic ones must be registered with gob.Register. The
+session is saved once the handler starts its response and only if it was changed, then again when the handler returns if it was
+changed meanwhile. It is safe for concurrent use.
+*/
+type Session struct {
+	mu sync.Mutex
+
+	id          string
+	previousID  string // The ID replaced by RegenerateID. It is removed from the store when the session is saved.
+	cookieValue string // The value of the session cookie held by the client. Empty when it has none.
+	values      map[string]any
+	flashes     []string
+	created     time.Time
+	lastAccess  time.Time
+
+	isNew     bool
+	modified  bool
+	destroyed bool
+}
+
+// The form of the session written to the stores.
+type record struct {
+	ID         string
+	Values     map[string]any
+	Flashes    []string
+	Created    time.Time
+	LastAccess time.Time
+}
+
+func newSession(now time.Time) *Session {
+	return &Session{
+		id:         newID(),
+		values:     make(map[string]any),
+		created:    now,
+		lastAccess: now,
+		isNew:      true,
+	}
+}
+
+// Returns the ID of the session. It changes when RegenerateID is called.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.id
+}
+
+// Reports if the session was created for this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.isNew
+}
+
+// Returns the time the session was created at.
+func (s *Session) Created() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.created
+}
+
+func (s *Session) Get(key string) (value any, exists bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, exists = s.values[key]
+
+	return
+}
+
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.values[key]; !exists {
+		return
+	}
+
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Removes every value and flash message of the session. The ID is kept.
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values = make(map[string]any)
+	s.flashes = nil
+	s.modified = true
+}
+
+// Returns the value of the key if it is a string.
+func (s *Session) GetString(key string) (string, bool) {
+	return Value[string](s, key)
+}
+
+// Returns the value of the key if it is an int.
+func (s *Session) GetInt(key string) (int, bool) {
+	return Value[int](s, key)
+}
+
+// Returns the value of the key if it is a bool.
+func (s *Session) GetBool(key string) (bool, bool) {
+	return Value[bool](s, key)
+}
+
+// Returns the value of the key if it has the type T. It reports false when the key does not exist or has another type.
+func Value[T any](s *Session, key string) (value T, ok bool) {
+	raw, exists := s.Get(key)
+	if !exists {
+		return
+	}
+
+	value, ok = raw.(T)
+
+	return
+}
+
+// Adds a message shown on the next request which reads the flash messages, usually after a redirect.
+func (s *Session) AddFlash(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flashes = append(s.flashes, message)
+	s.modified = true
+}
+
+// Returns the flash messages and removes them from the session.
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flashes := s.flashes
+	if len(flashes) != 0 {
+		s.flashes = nil
+		s.modified = true
+	}
+
+	return flashes
+}
+
+/*
+RegenerateID gives the session a new ID and keeps its values. Call it when the privileges of the client change, like on login or
+logout, so an ID set by an attacker before the change can not be used after it.
+*/
+func (s *Session) RegenerateID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only the ID known by the store has to be removed, the intermediate ones were never saved.
+	if s.previousID == "" && !s.isNew {
+		s.previousID = s.id
+	}
+
+	s.id = newID()
+	s.modified = true
+}
+
+// Destroy removes the session from the store and deletes the cookie of the client. The next request starts a new session.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values = make(map[string]any)
+	s.flashes = nil
+	s.destroyed = true
+}
+
+func (s *Session) encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	err := gob.NewEncoder(buffer).Encode(record{
+		ID:         s.id,
+		Values:     s.values,
+		Flashes:    s.flashes,
+		Created:    s.created,
+		LastAccess: s.lastAccess,
+	})
+
+	return buffer.Bytes(), err
+}
+
+func decodeSession(data []byte) (*Session, error) {
+	r := record{}
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Values == nil {
+		r.Values = make(map[string]any)
+	}
+
+	return &Session{
+		id:         r.ID,
+		values:     r.Values,
+		flashes:    r.Flashes,
+		created:    r.Created,
+		lastAccess: r.LastAccess,
+	}, nil
+}
+
+// The IDs have 256 bits of randomness so they can not be guessed. They only use characters which are safe in cookies and file names.
+func newID() string {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func isValidID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(32) {
+		return false
+	}
+
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
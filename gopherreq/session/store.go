@@ -0,0 +1,25 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrInvalidSessionID = errors.New("invalid session id")
+	ErrCookieTooLarge   = errors.New("session does not fit in a cookie")
+)
+
+/*
+Store keeps the encoded sessions between the requests.
+
+The value returned by Save is sent to the client in the session cookie and given back to Load on the next request. Server side
+stores return the ID while the cookie store returns the whole session. Load returns ErrSessionNotFound when there is no session
+for the value or when it has expired. Stores must be safe for concurrent use.
+*/
+type Store interface {
+	Load(value string) (data []byte, err error)
+	Save(id string, data []byte, expiry time.Time) (value string, err error)
+	Delete(id string) error
+}
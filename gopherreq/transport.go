@@ -0,0 +1,346 @@
+package gopherreq
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Idle connections kept per host when the transport does not set it.
+const DEFAULT_MAX_IDLE_CONNS_PER_HOST = 2
+
+// Time an idle connection is kept when the transport does not set it.
+const DEFAULT_IDLE_CONN_TIMEOUT = 90 * time.Second
+
+/*
+Transport opens the connections of the Client and keeps them open between the requests to the same host.
+
+Connections are pooled by scheme, host and port. Once a response body is read completely the connection goes back to the pool
+unless either side asked to close it. Every idle connection is watched by a background reader so one closed by the server is
+dropped from the pool as soon as it happens, and an idempotent request sent on a connection which turns out to be dead is retried once on a new one.
+
+It is safe for concurrent use and should be shared by the clients instead of being created per request.
+*/
+type Transport struct {
+	TLSConfig           *tls.Config   // Used for the https URIs. Defaults to the system roots with the host of the URI as server name.
+	MaxIdleConnsPerHost int           // Idle connections kept per host. Defaults to DEFAULT_MAX_IDLE_CONNS_PER_HOST. Negative disables the pool.
+	MaxConnsPerHost     int           // Connections open at once per host, idle ones included. Requests wait for a free one. Zero means no limit.
+	IdleConnTimeout     time.Duration // Time an idle connection is kept. Defaults to DEFAULT_IDLE_CONN_TIMEOUT.
+	DialTimeout         time.Duration // Time to open a connection, the TLS handshake included. Zero means no limit other than the context of the request.
+
+	mu    sync.Mutex
+	pools map[connKey]*hostPool
+}
+
+// DefaultTransport is used by the clients without their own transport.
+var DefaultTransport = &Transport{}
+
+type connKey struct {
+	scheme  string
+	address string // The host and port, lower cased.
+}
+
+// The connections of a single host.
+type hostPool struct {
+	idle    []*persistConn // The most recently used is last.
+	open    int            // Every open connection of the host, idle and in use.
+	waiters []chan *persistConn
+}
+
+// A connection owned by the transport.
+type persistConn struct {
+	transport *Transport
+	key       connKey
+	conn      net.Conn
+	reader    *bufio.Reader
+	idleTimer *time.Timer
+	reused    bool          // The connection served a request before.
+	watchDone chan struct{} // Closed once the reader watching the idle connection returned.
+	watchErr  error         // Why the watching read returned.
+}
+
+/*
+Returns a connection to the host, reusing an idle one when possible. When the host already has MaxConnsPerHost connections it
+waits for one to be released or for the context to end.
+*/
+func (t *Transport) getConn(ctx context.Context, scheme string, host string) (*persistConn, error) {
+	key := connKey{scheme: scheme, address: strings.ToLower(hostWithPort(scheme, host))}
+
+	for {
+		t.mu.Lock()
+		pool := t.pool(key)
+
+		if len(pool.idle) != 0 {
+			pc := pool.idle[len(pool.idle)-1]
+			pool.idle = pool.idle[:len(pool.idle)-1]
+			pc.idleTimer.Stop()
+			t.mu.Unlock()
+
+			if !pc.stopWatch() {
+				t.closeConn(pc)
+				continue
+			}
+
+			return pc, nil
+		}
+
+		if t.MaxConnsPerHost <= 0 || pool.open < t.MaxConnsPerHost {
+			pool.open++
+			t.mu.Unlock()
+
+			return t.dialConn(ctx, key)
+		}
+
+		waiter := make(chan *persistConn, 1)
+		pool.waiters = append(pool.waiters, waiter)
+		t.mu.Unlock()
+
+		select {
+		case pc := <-waiter:
+			// A nil connection hands over the slot of a closed one, so a new connection is opened for it.
+			if pc == nil {
+				return t.dialConn(ctx, key)
+			}
+			return pc, nil
+
+		case <-ctx.Done():
+			t.abandonWait(key, waiter)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Opens a new connection for a slot already counted in the pool.
+func (t *Transport) dialConn(ctx context.Context, key connKey) (*persistConn, error) {
+	conn, err := t.dial(ctx, key.scheme, key.address)
+	if err != nil {
+		t.releaseSlot(key)
+		return nil, err
+	}
+
+	return &persistConn{transport: t, key: key, conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Opens a connection to the address, over TLS for https.
+func (t *Transport) dial(ctx context.Context, scheme string, address string) (net.Conn, error) {
+	if scheme == "https" {
+		config := &tls.Config{}
+		if t.TLSConfig != nil {
+			config = t.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+
+		dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: t.DialTimeout}, Config: config}
+
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	dialer := &net.Dialer{Timeout: t.DialTimeout}
+
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// Gives the connection back once its response is read completely. It goes to a waiting request first, then to the idle pool.
+func (t *Transport) putIdle(pc *persistConn) {
+	pc.reused = true
+
+	t.mu.Lock()
+	pool := t.pool(pc.key)
+
+	if len(pool.waiters) != 0 {
+		waiter := pool.waiters[0]
+		pool.waiters = pool.waiters[1:]
+		t.mu.Unlock()
+
+		waiter <- pc
+		return
+	}
+
+	if len(pool.idle) >= t.maxIdleConnsPerHost() {
+		t.mu.Unlock()
+		t.closeConn(pc)
+		return
+	}
+
+	pool.idle = append(pool.idle, pc)
+	pc.idleTimer = time.AfterFunc(t.idleConnTimeout(), func() {
+		t.evict(pc)
+	})
+	pc.watchDone = make(chan struct{})
+	t.mu.Unlock()
+
+	go pc.watch()
+}
+
+// Closes the connection after the idle timeout if nobody took it meanwhile.
+func (t *Transport) evict(pc *persistConn) {
+	t.mu.Lock()
+	pool := t.pool(pc.key)
+
+	index := -1
+	for i, idle := range pool.idle {
+		if idle == pc {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		t.mu.Unlock()
+		return
+	}
+
+	pool.idle = append(pool.idle[:index], pool.idle[index+1:]...)
+	t.mu.Unlock()
+
+	t.closeConn(pc)
+}
+
+// Closes a connection which can not be reused and frees its slot.
+func (t *Transport) closeConn(pc *persistConn) {
+	pc.conn.Close()
+	t.releaseSlot(pc.key)
+}
+
+// Frees a connection slot of the host. A waiting request gets it to open its own connection.
+func (t *Transport) releaseSlot(key connKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pool := t.pool(key)
+
+	if len(pool.waiters) != 0 {
+		waiter := pool.waiters[0]
+		pool.waiters = pool.waiters[1:]
+		waiter <- nil
+		return
+	}
+
+	pool.open--
+
+	if pool.open == 0 && len(pool.idle) == 0 {
+		delete(t.pools, key)
+	}
+}
+
+// Removes a waiter whose context ended. If a connection was handed to it meanwhile it is given back.
+func (t *Transport) abandonWait(key connKey, waiter chan *persistConn) {
+	t.mu.Lock()
+	pool := t.pool(key)
+
+	for index, w := range pool.waiters {
+		if w == waiter {
+			pool.waiters = append(pool.waiters[:index], pool.waiters[index+1:]...)
+			t.mu.Unlock()
+			return
+		}
+	}
+	t.mu.Unlock()
+
+	// The waiter was already served so it holds a connection or a slot.
+	pc := <-waiter
+	if pc == nil {
+		t.releaseSlot(key)
+		return
+	}
+
+	t.putIdle(pc)
+}
+
+// Closes every idle connection. The connections in use are closed once their response is read.
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	idle := []*persistConn{}
+	for _, pool := range t.pools {
+		idle = append(idle, pool.idle...)
+		pool.idle = nil
+	}
+	t.mu.Unlock()
+
+	for _, pc := range idle {
+		pc.idleTimer.Stop()
+		t.closeConn(pc)
+	}
+}
+
+// Returns the pool of the host. The lock must be held.
+func (t *Transport) pool(key connKey) *hostPool {
+	if t.pools == nil {
+		t.pools = make(map[connKey]*hostPool)
+	}
+
+	pool, exists := t.pools[key]
+	if !exists {
+		pool = &hostPool{}
+		t.pools[key] = pool
+	}
+
+	return pool
+}
+
+func (t *Transport) maxIdleConnsPerHost() int {
+	if t.MaxIdleConnsPerHost == 0 {
+		return DEFAULT_MAX_IDLE_CONNS_PER_HOST
+	}
+
+	return max(t.MaxIdleConnsPerHost, 0)
+}
+
+func (t *Transport) idleConnTimeout() time.Duration {
+	if t.IdleConnTimeout <= 0 {
+		return DEFAULT_IDLE_CONN_TIMEOUT
+	}
+
+	return t.IdleConnTimeout
+}
+
+/*
+Watches the idle connection until it is taken again. Nothing is expected from the server between two responses, so when the
+read returns while the connection is still idle the server either closed it or sent something unexpected, and the connection
+is dropped from the pool right away.
+*/
+func (pc *persistConn) watch() {
+	defer close(pc.watchDone)
+
+	_, pc.watchErr = pc.reader.Peek(1)
+
+	t := pc.transport
+	t.mu.Lock()
+	pool := t.pool(pc.key)
+
+	for index, idle := range pool.idle {
+		if idle == pc {
+			pool.idle = append(pool.idle[:index], pool.idle[index+1:]...)
+			t.mu.Unlock()
+
+			pc.idleTimer.Stop()
+			t.closeConn(pc)
+			return
+		}
+	}
+	t.mu.Unlock()
+}
+
+/*
+Stops the reader watching a connection just taken from the pool by expiring its read deadline, and reports if the connection
+can still be used. It can not when the watching read returned for any reason other than the deadline.
+*/
+func (pc *persistConn) stopWatch() bool {
+	pc.conn.SetReadDeadline(aLongTimeAgo)
+	<-pc.watchDone
+	pc.conn.SetReadDeadline(time.Time{})
+
+	if pc.reader.Buffered() != 0 {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(pc.watchErr, &netErr) && netErr.Timeout()
+}
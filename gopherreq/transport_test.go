@@ -0,0 +1,487 @@
+package gopherreq
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"gopherreq/gopherreq/common"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const rawOKResponse = "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+
+/*
+Accepts connections on a local port and passes each one to the handler along with its index, counted from 0. Unlike the server
+of the package it lets the tests close or break the connections at any point. Returns the address and the count of accepted
+connections.
+*/
+func startRawServer(t *testing.T, handle func(index int, conn net.Conn, reader *bufio.Reader)) (string, *atomic.Int32) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	accepted := &atomic.Int32{}
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	conns := []net.Conn{}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+
+			index := int(accepted.Add(1)) - 1
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				handle(index, conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+
+	return listener.Addr().String(), accepted
+}
+
+// Reads the head of a request without body. It returns the error of the connection once the client closed it.
+func readRequestHead(reader *bufio.Reader) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == "\r\n" {
+			return nil
+		}
+	}
+}
+
+// Answers every request on the connection with a 200 until the client closes it.
+func serveOK(index int, conn net.Conn, reader *bufio.Reader) {
+	for readRequestHead(reader) == nil {
+		io.WriteString(conn, rawOKResponse)
+	}
+}
+
+// Returns the idle connections, the open connections and the waiting requests of the host.
+func poolState(transport *Transport, address string) (idle int, open int, waiters int) {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	pool, exists := transport.pools[connKey{scheme: "http", address: address}]
+	if !exists {
+		return 0, 0, 0
+	}
+
+	return len(pool.idle), pool.open, len(pool.waiters)
+}
+
+// Sends a GET to the address and reads the whole response.
+func get(ctx context.Context, client *Client, address string) (string, error) {
+	req := HttpRequest{Method: common.Get, URI: url.URL{Scheme: "http", Host: address, Path: "/"}}
+
+	resp, err := client.Do(*req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+
+	body, err := resp.ReadAll()
+
+	return string(body), err
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	address, accepted := startRawServer(t, serveOK)
+	transport := &Transport{}
+	client := &Client{Transport: transport, MaxRetries: -1}
+
+	for index := range 3 {
+		if body, err := get(context.Background(), client, address); err != nil || body != "ok" {
+			t.Fatalf("request %d = %q, %v", index, body, err)
+		}
+
+		// Leaves the time for the idle connection to be watched before it is taken again.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if count := accepted.Load(); count != 1 {
+		t.Fatalf("opened %d connections, want 1", count)
+	}
+
+	if idle, open, _ := poolState(transport, address); idle != 1 || open != 1 {
+		t.Fatalf("pool has %d idle and %d open connections, want 1 and 1", idle, open)
+	}
+
+	transport.CloseIdleConnections()
+
+	if idle, open, _ := poolState(transport, address); idle != 0 || open != 0 {
+		t.Fatalf("pool has %d idle and %d open connections after CloseIdleConnections, want none", idle, open)
+	}
+}
+
+// The watching reader drops an idle connection as soon as the server closes it, or sends something no request asked for.
+func TestTransportDropsBrokenIdleConnections(t *testing.T) {
+	tests := []struct {
+		name  string
+		after func(conn net.Conn)
+	}{
+		{"closed by the server", func(conn net.Conn) {}},
+		{"unexpected data", func(conn net.Conn) {
+			io.WriteString(conn, rawOKResponse)
+			io.Copy(io.Discard, conn)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address, accepted := startRawServer(t, func(index int, conn net.Conn, reader *bufio.Reader) {
+				if readRequestHead(reader) != nil {
+					return
+				}
+				io.WriteString(conn, rawOKResponse)
+
+				// The second connection serves normally.
+				if index != 0 {
+					serveOK(index, conn, reader)
+					return
+				}
+
+				time.Sleep(20 * time.Millisecond)
+				test.after(conn)
+			})
+
+			transport := &Transport{}
+			client := &Client{Transport: transport, MaxRetries: -1}
+
+			if _, err := get(context.Background(), client, address); err != nil {
+				t.Fatalf("first request: %v", err)
+			}
+
+			waitFor(t, "the idle connection to be dropped", func() bool {
+				idle, open, _ := poolState(transport, address)
+				return idle == 0 && open == 0
+			})
+
+			if body, err := get(context.Background(), client, address); err != nil || body != "ok" {
+				t.Fatalf("second request = %q, %v", body, err)
+			}
+
+			if count := accepted.Load(); count != 2 {
+				t.Fatalf("opened %d connections, want 2", count)
+			}
+		})
+	}
+}
+
+func TestTransportEvictsIdleConnections(t *testing.T) {
+	closed := make(chan struct{})
+	address, _ := startRawServer(t, func(index int, conn net.Conn, reader *bufio.Reader) {
+		serveOK(index, conn, reader)
+		close(closed)
+	})
+
+	transport := &Transport{IdleConnTimeout: 50 * time.Millisecond}
+	client := &Client{Transport: transport, MaxRetries: -1}
+
+	if _, err := get(context.Background(), client, address); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the idle connection was not closed after the idle timeout")
+	}
+
+	waitFor(t, "the pool to be emptied", func() bool {
+		idle, open, _ := poolState(transport, address)
+		return idle == 0 && open == 0
+	})
+}
+
+/*
+The server closes a pooled connection just as the next request is sent on it. The idempotent request is sent once more on a new
+connection, the others fail since the server may have handled them.
+*/
+func TestTransportRetriesDeadConnection(t *testing.T) {
+	tests := []struct {
+		method      common.HttpMethod
+		connections int32
+		retried     bool
+	}{
+		{common.Get, 2, true},
+		{common.Post, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.method), func(t *testing.T) {
+			address, accepted := startRawServer(t, func(index int, conn net.Conn, reader *bufio.Reader) {
+				if index != 0 {
+					serveOK(index, conn, reader)
+					return
+				}
+
+				if readRequestHead(reader) == nil {
+					io.WriteString(conn, rawOKResponse)
+				}
+				// The next request is read and dropped without an answer.
+				readRequestHead(reader)
+			})
+
+			transport := &Transport{}
+			client := &Client{Transport: transport, MaxRetries: -1}
+
+			if _, err := get(context.Background(), client, address); err != nil {
+				t.Fatalf("first request: %v", err)
+			}
+
+			req := HttpRequest{Method: test.method, URI: url.URL{Scheme: "http", Host: address, Path: "/"}}
+			resp, err := client.Do(req)
+
+			if test.retried {
+				if err != nil {
+					t.Fatalf("request on the dead connection: %v", err)
+				}
+				if body, err := resp.ReadAll(); err != nil || string(body) != "ok" {
+					t.Fatalf("body = %q, %v", body, err)
+				}
+			} else if !errors.Is(err, errNoResponse) {
+				t.Fatalf("request on the dead connection = %v, want errNoResponse", err)
+			}
+
+			if count := accepted.Load(); count != test.connections {
+				t.Fatalf("opened %d connections, want %d", count, test.connections)
+			}
+		})
+	}
+}
+
+// A request which failed on a new connection is not sent again.
+func TestTransportDoesNotRetryNewConnection(t *testing.T) {
+	address, accepted := startRawServer(t, func(index int, conn net.Conn, reader *bufio.Reader) {
+		readRequestHead(reader)
+	})
+
+	client := &Client{Transport: &Transport{}, MaxRetries: -1}
+
+	if _, err := get(context.Background(), client, address); !errors.Is(err, errNoResponse) {
+		t.Fatalf("request = %v, want errNoResponse", err)
+	}
+
+	if count := accepted.Load(); count != 1 {
+		t.Fatalf("opened %d connections, want 1", count)
+	}
+}
+
+// Starts a server whose first response waits for release. It returns the address.
+func startHeldServer(t *testing.T, release chan struct{}) (string, *atomic.Int32) {
+	t.Helper()
+
+	return startRawServer(t, func(index int, conn net.Conn, reader *bufio.Reader) {
+		if readRequestHead(reader) != nil {
+			return
+		}
+
+		<-release
+		io.WriteString(conn, rawOKResponse)
+		serveOK(index, conn, reader)
+	})
+}
+
+func TestTransportMaxConnsPerHost(t *testing.T) {
+	release := make(chan struct{})
+	address, accepted := startHeldServer(t, release)
+
+	transport := &Transport{MaxConnsPerHost: 1}
+	client := &Client{Transport: transport, MaxRetries: -1}
+
+	results := make(chan error, 3)
+	for range 3 {
+		go func() {
+			body, err := get(context.Background(), client, address)
+			if err == nil && body != "ok" {
+				err = errors.New("unexpected body " + body)
+			}
+			results <- err
+		}()
+	}
+
+	waitFor(t, "two requests to wait", func() bool {
+		_, open, waiters := poolState(transport, address)
+		return open == 1 && waiters == 2
+	})
+
+	close(release)
+
+	for range 3 {
+		if err := <-results; err != nil {
+			t.Fatalf("request: %v", err)
+		}
+	}
+
+	// The connection went from one request to the next.
+	if count := accepted.Load(); count != 1 {
+		t.Fatalf("opened %d connections, want 1", count)
+	}
+
+	if idle, open, waiters := poolState(transport, address); idle != 1 || open != 1 || waiters != 0 {
+		t.Fatalf("pool has %d idle, %d open connections and %d waiters, want 1, 1 and 0", idle, open, waiters)
+	}
+}
+
+// A request whose context ends while waiting gives up its place, and the connection released later goes to the pool.
+func TestTransportAbandonWait(t *testing.T) {
+	release := make(chan struct{})
+	address, _ := startHeldServer(t, release)
+
+	transport := &Transport{MaxConnsPerHost: 1}
+	client := &Client{Transport: transport, MaxRetries: -1}
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := get(context.Background(), client, address)
+		first <- err
+	}()
+
+	waitFor(t, "the first request to hold the connection", func() bool {
+		_, open, _ := poolState(transport, address)
+		return open == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := get(ctx, client, address); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting request = %v, want context.DeadlineExceeded", err)
+	}
+
+	if _, _, waiters := poolState(transport, address); waiters != 0 {
+		t.Fatalf("%d waiters left after the context ended", waiters)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+
+	if idle, open, _ := poolState(transport, address); idle != 1 || open != 1 {
+		t.Fatalf("pool has %d idle and %d open connections, want 1 and 1", idle, open)
+	}
+}
+
+// The context of a waiting request can end just after it was handed a connection or a slot, which must not be lost.
+func TestTransportAbandonWaitAfterHandover(t *testing.T) {
+	address, _ := startRawServer(t, serveOK)
+	key := connKey{scheme: "http", address: address}
+
+	transport := &Transport{MaxConnsPerHost: 1}
+	defer transport.CloseIdleConnections()
+
+	pc, err := transport.getConn(context.Background(), "http", address)
+	if err != nil {
+		t.Fatalf("getConn: %v", err)
+	}
+
+	addWaiter := func() chan *persistConn {
+		waiter := make(chan *persistConn, 1)
+		transport.mu.Lock()
+		pool := transport.pool(key)
+		pool.waiters = append(pool.waiters, waiter)
+		transport.mu.Unlock()
+
+		return waiter
+	}
+
+	// The connection is handed over and goes to the idle pool instead.
+	transport.abandonWait(key, func() chan *persistConn {
+		waiter := addWaiter()
+		transport.putIdle(pc)
+		return waiter
+	}())
+
+	if idle, open, waiters := poolState(transport, address); idle != 1 || open != 1 || waiters != 0 {
+		t.Fatalf("pool has %d idle, %d open connections and %d waiters, want 1, 1 and 0", idle, open, waiters)
+	}
+
+	pc, err = transport.getConn(context.Background(), "http", address)
+	if err != nil {
+		t.Fatalf("getConn of the idle connection: %v", err)
+	}
+
+	// The slot of a closed connection is handed over and freed again.
+	transport.abandonWait(key, func() chan *persistConn {
+		waiter := addWaiter()
+		transport.closeConn(pc)
+		return waiter
+	}())
+
+	if idle, open, waiters := poolState(transport, address); idle != 0 || open != 0 || waiters != 0 {
+		t.Fatalf("pool has %d idle, %d open connections and %d waiters, want none", idle, open, waiters)
+	}
+
+	// The freed slot can be taken.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if body, err := get(ctx, &Client{Transport: transport, MaxRetries: -1}, address); err != nil || body != "ok" {
+		t.Fatalf("request after the handovers = %q, %v", body, err)
+	}
+}
+
+// A connection taken from the pool has its watching reader stopped without losing what the server sends next.
+func TestTransportStopWatch(t *testing.T) {
+	address, accepted := startRawServer(t, serveOK)
+	transport := &Transport{}
+	defer transport.CloseIdleConnections()
+
+	client := &Client{Transport: transport, MaxRetries: -1}
+
+	for index := range 20 {
+		if body, err := get(context.Background(), client, address); err != nil || body != "ok" {
+			t.Fatalf("request %d = %q, %v", index, body, err)
+		}
+	}
+
+	if count := accepted.Load(); count != 1 {
+		t.Fatalf("opened %d connections, want 1", count)
+	}
+
+	pc, err := transport.getConn(context.Background(), "http", address)
+	if err != nil {
+		t.Fatalf("getConn: %v", err)
+	}
+	defer transport.closeConn(pc)
+
+	// The watching reader has returned and the deadline used to stop it is cleared, so the reads are the caller's again.
+	io.WriteString(pc.conn, "GET / HTTP/1.1\r\nHost: "+address+"\r\n\r\n")
+	if status := readResponseHead(t, pc.reader); status != "HTTP/1.1 200 OK" {
+		t.Fatalf("status = %q", status)
+	}
+}
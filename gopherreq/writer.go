@@ -0,0 +1,404 @@
+package gopherreq
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bodies up to this size are buffered so that the response can be sent with a Content-Length.
+const RESPONSE_BUFFER_BYTES = 4096
+
+/*
+The responseWriter is the ResponseWriter handed to the handlers by the server.
+
+The body is buffered until it exceeds RESPONSE_BUFFER_BYTES or the handler flushes. If the handler returns before that, the
+response is sent with a Content-Length. Otherwise the headers are sent and the rest of the body is streamed using the chunked
+transfer coding, unless the handler set the Content-Length itself.
+
+For HEAD requests the handler writes the body like for GET so the headers, Content-Length included, are the same, but the body
+itself is dropped.
+
+HTTP/1.0 clients do not understand the chunked coding, so a streamed body is sent as is and ended by closing the connection.
+*/
+type responseWriter struct {
+	mu          sync.Mutex // Guards the writer against the server aborting the response while a timed out handler still writes.
+	aborted     bool       // The server took over the response so the handler can not write anymore.
+	writer      *bufio.Writer
+	response    HttpWireResponse
+	body        bytes.Buffer
+	wroteHeader bool           // The handler has decided the status code.
+	headerSent  bool           // The response line and headers are written to the connection.
+	chunked     *chunkedWriter // Set when the body is streamed with chunked framing.
+	declaredLen int64          // The Content-Length set by the handler, -1 if not set.
+	written     int64          // The number of body bytes written to the connection.
+	head        bool           // The response is for a HEAD request so the body is never sent.
+	noChunked   bool           // The client does not understand the chunked coding.
+}
+
+func newResponseWriter(conn io.Writer, request *HttpRequest) *responseWriter {
+	return &responseWriter{
+		head:      request.Method == common.Head,
+		noChunked: !request.ProtoAtLeast(1, 1),
+		writer:    bufio.NewWriter(conn),
+		response: HttpWireResponse{
+			Headers:      make(Headers),
+			ResponseLine: ResponseLine{Version: SERVER_HTTP_VERSION},
+		},
+		declaredLen: -1,
+	}
+}
+
+func (w *responseWriter) Header() Headers {
+	return w.response.Headers
+}
+
+func (w *responseWriter) WriteHeader(code common.StatusCode) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writeHeader(code)
+}
+
+func (w *responseWriter) writeHeader(code common.StatusCode) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	w.response.ResponseLine.Code = code
+	w.response.ResponseLine.Reason = httpStatusPhraseReasons[code]
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.aborted {
+		return 0, httperr.ErrHandlerTimeout
+	}
+
+	if !w.wroteHeader {
+		w.writeHeader(OK)
+	}
+
+	if !bodyAllowedForStatus(w.response.ResponseLine.Code) {
+		return 0, httperr.ErrBodyNotAllowed
+	}
+
+	if !w.headerSent {
+		if w.body.Len()+len(data) <= RESPONSE_BUFFER_BYTES {
+			return w.body.Write(data)
+		}
+
+		// The body does not fit in the buffer so switch to streaming. The data joins the buffer first so its length is checked
+		// against the declared one before the header goes out.
+		w.body.Write(data)
+
+		err := w.sendHeader(false)
+		if err != nil {
+			return 0, err
+		}
+
+		err = w.writeBuffered()
+		if err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
+	return w.writeBody(data)
+}
+
+func (w *responseWriter) Flush() (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.aborted {
+		return httperr.ErrHandlerTimeout
+	}
+
+	if !w.wroteHeader {
+		w.writeHeader(OK)
+	}
+
+	if !w.headerSent {
+		err = w.sendHeader(false)
+		if err != nil {
+			return
+		}
+
+		err = w.writeBuffered()
+		if err != nil {
+			return
+		}
+	}
+
+	return w.writer.Flush()
+}
+
+// Completes the response once the handler has returned.
+func (w *responseWriter) finish() (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.wroteHeader {
+		w.writeHeader(OK)
+	}
+
+	if !w.headerSent {
+		err = w.sendHeader(true)
+		if err != nil {
+			return
+		}
+
+		err = w.writeBuffered()
+		if err != nil {
+			return
+		}
+	}
+
+	if w.chunked != nil && !w.head {
+		err = w.chunked.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	return w.writer.Flush()
+}
+
+// Throws away what the handler wrote if none of it was sent so a different response can be written.
+func (w *responseWriter) resetResponse() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.headerSent || w.aborted {
+		return false
+	}
+
+	w.wroteHeader = false
+	w.body.Reset()
+	w.response.ResponseLine.Code = 0
+	w.response.ResponseLine.Reason = ""
+
+	// The framing and connection management headers set by the server are kept.
+	connection := w.response.Headers.GetAllValues("Connection")
+	clear(w.response.Headers)
+	if len(connection) != 0 {
+		w.response.Headers["Connection"] = connection
+	}
+
+	return true
+}
+
+// Takes the response away from the handler. If nothing was sent yet the error is sent instead, otherwise the response is left truncated.
+func (w *responseWriter) abort(httpErr *httperr.HTTPError) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.abortLocked(httpErr)
+}
+
+func (w *responseWriter) abortLocked(httpErr *httperr.HTTPError) {
+	w.aborted = true
+
+	if w.headerSent {
+		return
+	}
+
+	w.headerSent = true
+	w.body.Reset()
+	w.response.ResponseLine.Code = httpErr.Code
+	w.written = 0
+
+	response := newErrorResponse(httpErr)
+	if w.head {
+		response.Body = nil
+	}
+
+	if writeResponse(response, w.writer) == nil {
+		w.writer.Flush()
+		if !w.head {
+			w.written = int64(len(httpErr.Reason))
+		}
+	}
+}
+
+// Returns the status of the response and the number of body bytes sent for the access log.
+func (w *responseWriter) result() (status common.StatusCode, written int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.response.ResponseLine.Code, w.written
+}
+
+// Reports if the connection can not be reused after the response. It happens when the handler asks for it, when the body did not match the declared length or when the server took the response over.
+func (w *responseWriter) shouldClose() bool {
+	if w.aborted || w.response.Headers.HasToken("Connection", "close") {
+		return true
+	}
+
+	return w.declaredLen >= 0 && w.written != w.declaredLen && bodyAllowedForStatus(w.response.ResponseLine.Code) && !w.head
+}
+
+// Decides the framing of the body and writes the response line with the headers. The final flag is set when the whole body is buffered.
+func (w *responseWriter) sendHeader(final bool) (err error) {
+	w.headerSent = true
+
+	headers := w.response.Headers
+
+	if rawLen := headers.Get("Content-Length"); rawLen != "" {
+		declaredLen, parseErr := strconv.ParseInt(rawLen.String(), 10, 64)
+		if parseErr != nil || declaredLen < 0 {
+			// Ignore an invalid length set by the handler and frame the body ourselves.
+			headers.Remove("Content-Length")
+		} else {
+			w.declaredLen = declaredLen
+		}
+	}
+
+	bodyAllowed := bodyAllowedForStatus(w.response.ResponseLine.Code)
+	closeDelimited := false
+
+	// A buffered body longer than the declared length can not be sent, the client would get the header and a cut body.
+	if bodyAllowed && !w.head && w.declaredLen >= 0 && int64(w.body.Len()) > w.declaredLen {
+		w.headerSent = false
+		w.abortLocked(httperr.ErrContentLengthLimit)
+		return httperr.ErrContentLengthLimit
+	}
+
+	switch {
+	case !bodyAllowed:
+		headers.Remove("Transfer-Encoding")
+
+	case w.noChunked && w.declaredLen < 0 && !final:
+		// Without a length the end of the body can only be marked by closing the connection.
+		headers.Remove("Transfer-Encoding")
+		headers.Set("Connection", "close")
+		closeDelimited = true
+
+	case w.noChunked && w.response.IsChunked():
+		headers.Remove("Transfer-Encoding")
+		if w.declaredLen < 0 {
+			headers.Set("Content-Length", HeaderValue(strconv.Itoa(w.body.Len())))
+		}
+
+	case w.response.IsChunked():
+		w.chunked = newChunkedWriter(w.writer)
+
+	case w.declaredLen >= 0:
+		// The handler knows the length, so the body is sent as is.
+
+	case final:
+		headers.Set("Content-Length", HeaderValue(strconv.Itoa(w.body.Len())))
+
+	default:
+		headers.Set("Transfer-Encoding", "chunked")
+		w.chunked = newChunkedWriter(w.writer)
+	}
+
+	w.response.StandardizeHeaders()
+
+	// The default length of zero set for the responses without one would cut the body.
+	if closeDelimited {
+		headers.Remove("Content-Length")
+	}
+
+	return writeHeaderResponse(w.response, w.writer)
+}
+
+func (w *responseWriter) writeBuffered() (err error) {
+	if w.body.Len() == 0 {
+		return
+	}
+
+	_, err = w.writeBody(w.body.Bytes())
+	w.body.Reset()
+
+	return
+}
+
+func (w *responseWriter) writeBody(data []byte) (n int, err error) {
+	if len(data) == 0 {
+		return
+	}
+
+	// The headers of a HEAD response are the ones of the GET response, only the body is left out.
+	if w.head {
+		return len(data), nil
+	}
+
+	if w.declaredLen >= 0 && w.written+int64(len(data)) > w.declaredLen {
+		return 0, httperr.ErrContentLengthLimit
+	}
+
+	if w.chunked != nil {
+		n, err = w.chunked.Write(data)
+	} else {
+		n, err = w.writer.Write(data)
+	}
+
+	w.written += int64(n)
+
+	return
+}
+
+// Extends the write deadline of the connection before every write. The timeout limits how long the client can take to accept each part of the response.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w deadlineWriter) Write(data []byte) (int, error) {
+	if w.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+
+	return w.conn.Write(data)
+}
+
+// Frames every write as a single chunk of the chunked transfer coding. Close writes the last chunk.
+type chunkedWriter struct {
+	writer io.Writer
+}
+
+func newChunkedWriter(writer io.Writer) *chunkedWriter {
+	return &chunkedWriter{writer: writer}
+}
+
+func (c *chunkedWriter) Write(data []byte) (n int, err error) {
+	// A zero sized chunk marks the end of the body so it must never be sent for an empty write.
+	if len(data) == 0 {
+		return
+	}
+
+	_, err = fmt.Fprintf(c.writer, "%x%s", len(data), common.CRLF)
+	if err != nil {
+		return
+	}
+
+	n, err = c.writer.Write(data)
+	if err != nil {
+		return
+	}
+
+	_, err = io.WriteString(c.writer, common.CRLF)
+
+	return
+}
+
+// Writes the last chunk followed by an empty trailer section.
+func (c *chunkedWriter) Close() (err error) {
+	_, err = io.WriteString(c.writer, "0"+common.CRLF+common.CRLF)
+
+	return
+}
@@ -0,0 +1,225 @@
+package gopherreq
+
+import (
+	"bytes"
+	"errors"
+	"gopherreq/gopherreq/common"
+	"gopherreq/gopherreq/httperr"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResponseWriterFraming(t *testing.T) {
+	large := strings.Repeat("x", RESPONSE_BUFFER_BYTES+904)
+
+	tests := []struct {
+		name       string
+		method     common.HttpMethod
+		http10     bool
+		handler    func(w ResponseWriter) error
+		status     string
+		headers    []string // Lines expected in the header section.
+		notHeaders []string // Field names which must not be sent.
+		body       string
+		handlerErr error
+		finishErr  error
+		close      bool
+	}{
+		{
+			name:       "buffered",
+			handler:    func(w ResponseWriter) error { return write(w, "hello") },
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Content-Length: 5"},
+			notHeaders: []string{"Transfer-Encoding"},
+			body:       "hello",
+		},
+		{
+			name:       "empty",
+			handler:    func(w ResponseWriter) error { return nil },
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Content-Length: 0"},
+			notHeaders: []string{"Transfer-Encoding"},
+		},
+		{
+			name:       "larger than the buffer",
+			handler:    func(w ResponseWriter) error { return write(w, large) },
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Transfer-Encoding: chunked"},
+			notHeaders: []string{"Content-Length"},
+			body:       "1388\r\n" + large + "\r\n0\r\n\r\n",
+		},
+		{
+			name: "flushed",
+			handler: func(w ResponseWriter) error {
+				return errors.Join(write(w, "a"), w.Flush(), write(w, "bc"))
+			},
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Transfer-Encoding: chunked"},
+			notHeaders: []string{"Content-Length"},
+			body:       "1\r\na\r\n2\r\nbc\r\n0\r\n\r\n",
+		},
+		{
+			name: "flushed with a declared length",
+			handler: func(w ResponseWriter) error {
+				w.Header().Set("Content-Length", "3")
+				return errors.Join(write(w, "a"), w.Flush(), write(w, "bc"))
+			},
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Content-Length: 3"},
+			notHeaders: []string{"Transfer-Encoding"},
+			body:       "abc",
+		},
+		{
+			name:    "HTTP/1.0 buffered",
+			http10:  true,
+			handler: func(w ResponseWriter) error { return write(w, "hello") },
+			status:  "HTTP/1.1 200 OK",
+			headers: []string{"Content-Length: 5"},
+			body:    "hello",
+		},
+		{
+			name: "HTTP/1.0 streamed",
+			handler: func(w ResponseWriter) error {
+				return errors.Join(write(w, "a"), w.Flush(), write(w, "bc"))
+			},
+			http10:     true,
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Connection: close"},
+			notHeaders: []string{"Content-Length", "Transfer-Encoding"},
+			body:       "abc",
+			close:      true,
+		},
+		{
+			name:       "HEAD",
+			method:     common.Head,
+			handler:    func(w ResponseWriter) error { return write(w, "hello") },
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Content-Length: 5"},
+			notHeaders: []string{"Transfer-Encoding"},
+		},
+		{
+			name:       "HEAD larger than the buffer",
+			method:     common.Head,
+			handler:    func(w ResponseWriter) error { return write(w, large) },
+			status:     "HTTP/1.1 200 OK",
+			headers:    []string{"Transfer-Encoding: chunked"},
+			notHeaders: []string{"Content-Length"},
+		},
+		{
+			name: "204",
+			handler: func(w ResponseWriter) error {
+				w.WriteHeader(NO_CONTENT)
+				return write(w, "ignored")
+			},
+			status:     "HTTP/1.1 204 No Content",
+			notHeaders: []string{"Content-Length", "Transfer-Encoding"},
+			handlerErr: httperr.ErrBodyNotAllowed,
+		},
+		{
+			name: "304",
+			handler: func(w ResponseWriter) error {
+				w.Header().Set("Transfer-Encoding", "chunked")
+				w.WriteHeader(NOT_MODIFIED)
+				return nil
+			},
+			status:     "HTTP/1.1 304 Not Modified",
+			notHeaders: []string{"Content-Length", "Transfer-Encoding"},
+		},
+		{
+			name: "declared length shorter than the buffered body",
+			handler: func(w ResponseWriter) error {
+				w.Header().Set("Content-Length", "3")
+				return write(w, "hello")
+			},
+			status:    "HTTP/1.1 500 Internal Server Error",
+			headers:   []string{"Content-Length: 21"},
+			body:      "Internal Server Error",
+			finishErr: httperr.ErrContentLengthLimit,
+			close:     true,
+		},
+		{
+			name: "declared length shorter than the streamed body",
+			handler: func(w ResponseWriter) error {
+				w.Header().Set("Content-Length", "3")
+				return write(w, large)
+			},
+			status:     "HTTP/1.1 500 Internal Server Error",
+			body:       "Internal Server Error",
+			handlerErr: httperr.ErrContentLengthLimit,
+			close:      true,
+		},
+		{
+			name: "declared length longer than the body",
+			handler: func(w ResponseWriter) error {
+				w.Header().Set("Content-Length", "10")
+				return write(w, "hello")
+			},
+			status:  "HTTP/1.1 200 OK",
+			headers: []string{"Content-Length: 10"},
+			body:    "hello",
+			close:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = common.Get
+			}
+
+			minor := 1
+			if test.http10 {
+				minor = 0
+			}
+
+			var output bytes.Buffer
+			w := newResponseWriter(&output, &HttpRequest{Method: method, ProtoMajor: 1, ProtoMinor: minor})
+
+			if err := test.handler(w); !errors.Is(err, test.handlerErr) {
+				t.Fatalf("handler error = %v, want %v", err, test.handlerErr)
+			}
+			if err := w.finish(); !errors.Is(err, test.finishErr) {
+				t.Fatalf("finish error = %v, want %v", err, test.finishErr)
+			}
+
+			header, body, found := strings.Cut(output.String(), "\r\n\r\n")
+			if !found {
+				t.Fatalf("no end of header in %q", output.String())
+			}
+
+			lines := strings.Split(header, "\r\n")
+			if lines[0] != test.status {
+				t.Fatalf("status line = %q, want %q", lines[0], test.status)
+			}
+
+			for _, want := range test.headers {
+				if !slices.Contains(lines[1:], want) {
+					t.Fatalf("header %q missing in %q", want, lines[1:])
+				}
+			}
+			for _, name := range test.notHeaders {
+				for _, line := range lines[1:] {
+					if strings.HasPrefix(line, name+":") {
+						t.Fatalf("header %q must not be sent", line)
+					}
+				}
+			}
+
+			if body != test.body {
+				t.Fatalf("body = %q, want %q", body, test.body)
+			}
+
+			if close := w.shouldClose(); close != test.close {
+				t.Fatalf("shouldClose = %v, want %v", close, test.close)
+			}
+		})
+	}
+}
+
+func write(w ResponseWriter, data string) error {
+	_, err := w.Write([]byte(data))
+
+	return err
+}
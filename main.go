@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gopherreq/gopherreq"
+	"gopherreq/gopherreq/httperr"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Time given to the in-flight requests to finish once a stop signal is received.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 
 	godotenv.Load()
@@ -17,21 +26,57 @@ func main() {
 		address = "localhost:8811"
 	}
 
+	router := gopherreq.NewRouter()
+	router.Get("/", func(w gopherreq.ResponseWriter, req *gopherreq.HttpRequest) {
+		w.Write([]byte("Hello from GopherReq"))
+	})
+
 	config := gopherreq.Config{
-		Domain:  address,
-		Timeout: 4000,
+		Domain:            address,
+		Handler:           router,
+		Middlewares:       []gopherreq.Middleware{gopherreq.Recovery, gopherreq.RequestID},
+		AccessLog:         &gopherreq.AccessLogConfig{Format: gopherreq.CombinedLogFormat, Output: os.Stdout},
+		ReadHeaderTimeout: 4 * time.Second,
+		ReadBodyTimeout:   30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
 	}
 
 	server, err := gopherreq.NewServer(config)
 
-	fmt.Printf("Server started listening and is accepting connections on the fly.")
-
 	if err != nil {
 		fmt.Printf("Error ocurred while listening on server - %v", err)
 		os.Exit(1)
 	}
 
-	server.Listen()
+	fmt.Printf("Server started listening and is accepting connections on the fly.\n")
+
+	stopCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- server.Listen()
+	}()
+
+	select {
+	case err = <-listenErr:
+		fmt.Printf("Server stopped listening - %v\n", err)
+		os.Exit(1)
+	case <-stopCtx.Done():
+	}
+
+	fmt.Printf("Shutting down the server.\n")
 
-	defer server.ShutDown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		fmt.Printf("Error while shutting down the server - %v\n", err)
+	}
+
+	if err = <-listenErr; !errors.Is(err, httperr.ErrServerClosed) {
+		fmt.Printf("Server stopped listening - %v\n", err)
+	}
 }